@@ -2,12 +2,12 @@ module docker-machine-driver-utm
 
 go 1.23.1
 
+require github.com/docker/machine v0.16.2
+
 require (
 	github.com/Azure/go-ansiterm v0.0.0-20210617225240-d185dfc1b5a1 // indirect
 	github.com/Sirupsen/logrus v1.0.6 // indirect
-	github.com/andybrewer/mack v0.0.0-20220307193339-22e922cc18af
 	github.com/docker/docker v1.13.1 // indirect
-	github.com/docker/machine v0.16.2 // indirect
 	golang.org/x/crypto v0.29.0 // indirect
 	golang.org/x/sys v0.27.0 // indirect
 	golang.org/x/term v0.26.0 // indirect
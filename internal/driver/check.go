@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	minUtmMajor = 4
+	minUtmMinor = 2
+
+	minMemory = 256
+	minDisk   = 512
+)
+
+func (d *Driver) PreCreateCheck() error {
+	if err := d.checkConfig(); err != nil {
+		return err
+	}
+
+	version, err := d.utmClient().Version()
+	if err != nil {
+		return fmt.Errorf("UTM is not installed or cannot be scripted: %w", err)
+	}
+	ok, err := versionAtLeast(version, minUtmMajor, minUtmMinor)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("UTM %s is too old, %d.%d or later is required", version, minUtmMajor, minUtmMinor)
+	}
+
+	vms, err := d.utmClient().ListVMs()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("docker-machine-%s", d.MachineName)
+	for _, vm := range vms {
+		if vm.Name == name {
+			return fmt.Errorf("a UTM VM named %q already exists", name)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) checkConfig() error {
+	switch mode := utm.QemuNetworkMode(d.Network); mode {
+	case utm.QemuNetworkModeEmulated, utm.QemuNetworkModeShared, utm.QemuNetworkModeHost:
+	case utm.QemuNetworkModeBridged:
+		if d.HostInterface == "" {
+			return fmt.Errorf("bridged networking requires --utm-host-interface")
+		}
+		if _, err := net.InterfaceByName(d.HostInterface); err != nil {
+			return fmt.Errorf("host interface %q: %w", d.HostInterface, err)
+		}
+	default:
+		return fmt.Errorf("unknown network type %q, expected one of emulated, shared, host, bridged", d.Network)
+	}
+	for _, nic := range d.NetworkInterfaces {
+		if nic.Mode != string(utm.QemuNetworkModeBridged) {
+			continue
+		}
+		if nic.HostInterface == "" {
+			return fmt.Errorf("bridged network interface requires a host interface, e.g. bridged:en0")
+		}
+		if _, err := net.InterfaceByName(nic.HostInterface); err != nil {
+			return fmt.Errorf("host interface %q: %w", nic.HostInterface, err)
+		}
+	}
+
+	if d.Memory < minMemory {
+		return fmt.Errorf("memory must be at least %d MB, got %d", minMemory, d.Memory)
+	}
+	if d.Disk < minDisk {
+		return fmt.Errorf("disk must be at least %d MB, got %d", minDisk, d.Disk)
+	}
+	if d.CPU < 1 || d.CPU > runtime.NumCPU() {
+		return fmt.Errorf("cpu count must be between 1 and %d, got %d", runtime.NumCPU(), d.CPU)
+	}
+	return nil
+}
+
+// versionAtLeast compares the leading major.minor of a version string such
+// as "4.6.4" or "4.7.0 (beta)".
+func versionAtLeast(version string, major, minor int) (bool, error) {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return false, fmt.Errorf("invalid UTM version %q", version)
+	}
+	parts := strings.Split(fields[0], ".")
+	v := make([]int, 2)
+	for i := 0; i < len(v) && i < len(parts); i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return false, fmt.Errorf("invalid UTM version %q", version)
+		}
+		v[i] = n
+	}
+	if v[0] != major {
+		return v[0] > major, nil
+	}
+	return v[1] >= minor, nil
+}
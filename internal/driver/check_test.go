@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"testing"
+)
+
+func TestPreCreateCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		modify  func(d *Driver, b *utmtest.Backend)
+		wantErr bool
+	}{
+		{name: "ok", modify: func(d *Driver, b *utmtest.Backend) {}},
+		{name: "old utm", modify: func(d *Driver, b *utmtest.Backend) { b.Version = "4.1.6" }, wantErr: true},
+		{name: "unknown network", modify: func(d *Driver, b *utmtest.Backend) { d.Network = "nat" }, wantErr: true},
+		{name: "bridged without interface", modify: func(d *Driver, b *utmtest.Backend) { d.Network = "bridged" }, wantErr: true},
+		{name: "bridged with missing interface", modify: func(d *Driver, b *utmtest.Backend) {
+			d.Network = "bridged"
+			d.HostInterface = "does-not-exist0"
+		}, wantErr: true},
+		{name: "bridged interface without host interface", modify: func(d *Driver, b *utmtest.Backend) {
+			d.NetworkInterfaces = []NetworkInterface{{Mode: "bridged"}}
+		}, wantErr: true},
+		{name: "bridged interface with missing host interface", modify: func(d *Driver, b *utmtest.Backend) {
+			d.NetworkInterfaces = []NetworkInterface{{Mode: "host"}, {Mode: "bridged", HostInterface: "does-not-exist0"}}
+		}, wantErr: true},
+		{name: "too little memory", modify: func(d *Driver, b *utmtest.Backend) { d.Memory = 16 }, wantErr: true},
+		{name: "too little disk", modify: func(d *Driver, b *utmtest.Backend) { d.Disk = 1 }, wantErr: true},
+		{name: "no cpu", modify: func(d *Driver, b *utmtest.Backend) { d.CPU = 0 }, wantErr: true},
+		{name: "existing vm", modify: func(d *Driver, b *utmtest.Backend) {
+			b.AddVM("docker-machine-test", utm.VmStatusStopped)
+		}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := utmtest.New()
+			d := newTestDriver(t, backend)
+			d.Disk = 8192
+			tc.modify(d, backend)
+
+			err := d.PreCreateCheck()
+			if tc.wantErr && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	for version, want := range map[string]bool{
+		"4.2":          true,
+		"4.2.0":        true,
+		"4.6.4":        true,
+		"4.7.0 (beta)": true,
+		"5.0":          true,
+		"4.1.6":        false,
+		"3.9":          false,
+	} {
+		got, err := versionAtLeast(version, 4, 2)
+		if err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %t, got %t", version, want, got)
+		}
+	}
+	if _, err := versionAtLeast("unknown", 4, 2); err == nil {
+		t.Fatal("expected invalid version to be rejected")
+	}
+}
@@ -0,0 +1,58 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CopyToGuest streams a host file into the guest through the guest agent, so
+// it works even when SSH does not.
+func (d *Driver) CopyToGuest(src, dst string) error {
+	if err := d.validateVM(); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := d.utmClient().OpenGuestFile(d.VM, dst, utm.GuestFileModeWriting)
+	if err != nil {
+		return fmt.Errorf("opening %s in the guest: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Join(fmt.Errorf("copying %s to the guest: %w", src, err), out.Close())
+	}
+	return out.Close()
+}
+
+// CopyFromGuest streams a guest file to the host through the guest agent.
+func (d *Driver) CopyFromGuest(src, dst string) (err error) {
+	if err := d.validateVM(); err != nil {
+		return err
+	}
+
+	in, err := d.utmClient().OpenGuestFile(d.VM, src, utm.GuestFileModeReading)
+	if err != nil {
+		return fmt.Errorf("opening %s in the guest: %w", src, err)
+	}
+	defer func() {
+		err = errors.Join(err, in.Close())
+	}()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("copying %s from the guest: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,309 @@
+package driver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	downloadRetries = 5
+	downloadBackoff = 2 * time.Second
+	// downloadIdleTimeout aborts a transfer that stalls for this long; the
+	// next attempt resumes it.
+	downloadIdleTimeout = 60 * time.Second
+)
+
+// downloader fetches large files over http(s). It honors HTTP(S)_PROXY,
+// resumes partial files with range requests and retries transient failures.
+type downloader struct {
+	client      *http.Client
+	retries     int
+	backoff     time.Duration
+	idleTimeout time.Duration
+}
+
+type statusError struct {
+	url    string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("download %s: %s", e.url, e.status)
+}
+
+func (e *statusError) temporary() bool {
+	return e.code >= 500 || e.code == http.StatusRequestTimeout || e.code == http.StatusTooManyRequests
+}
+
+// newDownloader returns a downloader trusting the system roots and, when
+// caBundle is set, the PEM certificates in that file.
+func newDownloader(caBundle string) (*downloader, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+	}
+
+	if caBundle != "" {
+		pem, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &downloader{
+		client:      &http.Client{Transport: transport},
+		retries:     downloadRetries,
+		backoff:     downloadBackoff,
+		idleTimeout: downloadIdleTimeout,
+	}, nil
+}
+
+// download fetches src into dest. If dest already holds the beginning of the
+// file from an earlier attempt, only the remainder is requested.
+func (dl *downloader) download(src, dest string) error {
+	var err error
+	for attempt := 0; attempt <= dl.retries; attempt++ {
+		if attempt > 0 {
+			wait := dl.backoff << (attempt - 1)
+			log.Warnf("Download failed: %v. Retrying in %s...", err, wait)
+			time.Sleep(wait)
+		}
+
+		err = dl.fetch(src, dest)
+		if err == nil {
+			return nil
+		}
+		if permanent(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// permanent reports whether retrying a failed fetch cannot help: the server
+// refused the request, or its certificate is not trusted.
+func permanent(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return !se.temporary()
+	}
+	var verify *tls.CertificateVerificationError
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &verify) || errors.As(err, &unknown) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
+// validatorPath is where the ETag or Last-Modified of a partial file is kept,
+// so a resumed request only continues the same version of the file.
+func validatorPath(dest string) string {
+	return dest + ".validator"
+}
+
+func (dl *downloader) fetch(src, dest string) error {
+	var offset int64
+	validator, _ := os.ReadFile(validatorPath(dest))
+	if fi, err := os.Stat(dest); err == nil {
+		offset = fi.Size()
+	}
+	if offset > 0 && len(validator) == 0 {
+		// Without a validator the partial file may belong to another
+		// version of the file.
+		offset = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(validator))
+	}
+
+	resp, err := dl.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	total := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+		if err := saveValidator(dest, resp.Header); err != nil {
+			return err
+		}
+	case http.StatusPartialContent:
+		start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			os.Remove(dest)
+			os.Remove(validatorPath(dest))
+			return &statusError{url: src, code: http.StatusServiceUnavailable, status: "unexpected Content-Range " + resp.Header.Get("Content-Range")}
+		}
+		log.Infof("Resuming download at %d bytes", offset)
+		flags |= os.O_APPEND
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is unusable; start over on the next attempt.
+		os.Remove(dest)
+		os.Remove(validatorPath(dest))
+		return &statusError{url: src, code: http.StatusServiceUnavailable, status: resp.Status}
+	default:
+		return &statusError{url: src, code: resp.StatusCode, status: resp.Status}
+	}
+	f, err := os.OpenFile(dest, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	pw := &progressWriter{name: src, written: offset, total: total}
+	body := io.Reader(resp.Body)
+	var stalled *idleReader
+	if dl.idleTimeout > 0 {
+		stalled = newIdleReader(resp.Body, dl.idleTimeout, cancel)
+		defer stalled.stop()
+		body = stalled
+	}
+	_, err = io.Copy(io.MultiWriter(f, pw), body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if stalled != nil && stalled.expired() {
+			return fmt.Errorf("download %s stalled: no data for %s", src, dl.idleTimeout)
+		}
+		return err
+	}
+	os.Remove(validatorPath(dest))
+	log.Infof("Downloaded %s (%d bytes)", src, pw.written)
+	return nil
+}
+
+// saveValidator records the ETag or, failing that, the Last-Modified date of
+// a response for resuming it later. Weak ETags cannot be used in If-Range.
+func saveValidator(dest string, header http.Header) error {
+	validator := header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		err := os.Remove(validatorPath(dest))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(validatorPath(dest), []byte(validator), 0644)
+}
+
+// idleReader cancels a transfer when no data arrives within timeout.
+type idleReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+func newIdleReader(r io.Reader, timeout time.Duration, cancel func()) *idleReader {
+	ir := &idleReader{r: r, timeout: timeout}
+	ir.timer = time.AfterFunc(timeout, func() {
+		ir.fired.Store(true)
+		cancel()
+	})
+	return ir
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+func (ir *idleReader) expired() bool { return ir.fired.Load() }
+
+func (ir *idleReader) stop() { ir.timer.Stop() }
+
+// parseContentRange reads the start and the complete length from a header
+// such as "bytes 100-199/200".
+func parseContentRange(header string) (start, size int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %s", header)
+	}
+	rng, length, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %s", header)
+	}
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range: %s", header)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	size = -1
+	if length != "*" {
+		if size, err = strconv.ParseInt(length, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, size, nil
+}
+
+// progressWriter logs download progress in 10% steps, or every 50 MB when
+// the total size is unknown.
+type progressWriter struct {
+	name     string
+	written  int64
+	total    int64
+	reported int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	if p.total > 0 {
+		step := p.written * 10 / p.total
+		if step > p.reported {
+			p.reported = step
+			log.Infof("Downloading %s: %d%%", p.name, step*10)
+		}
+	} else if step := p.written / (50 << 20); step > p.reported {
+		p.reported = step
+		log.Infof("Downloading %s: %d MB", p.name, p.written>>20)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,225 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// interruptedServer serves data with an ETag, dropping the connection after
+// cut bytes of the first response.
+func interruptedServer(t *testing.T, data []byte, etag string, cut int, ranges *[]string) *httptest.Server {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range")+" "+r.Header.Get("If-Range"))
+		w.Header().Set("ETag", etag)
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:cut])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "boot2docker.iso", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := testISO()
+	var ranges []string
+	srv := interruptedServer(t, data, `"v1"`, 1000, &ranges)
+
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	if err := testDownloader().download(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("resumed download does not match the source")
+	}
+	if len(ranges) != 2 || ranges[1] != `bytes=1000- "v1"` {
+		t.Fatalf("unexpected range requests: %q", ranges)
+	}
+	if _, err := os.Stat(validatorPath(dest)); !os.IsNotExist(err) {
+		t.Fatalf("expected the validator to be removed, got %v", err)
+	}
+}
+
+func TestDownloadResumeChanged(t *testing.T) {
+	old := testISO()
+	var ranges []string
+	srv := interruptedServer(t, old, `"v1"`, 1000, &ranges)
+	dl := testDownloader()
+	dl.retries = 0
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	if err := dl.download(srv.URL, dest); err == nil {
+		t.Fatal("expected the interrupted download to fail")
+	}
+
+	data := testISO()
+	data[len(data)-1] = 1
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "boot2docker.iso", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+	if err := dl.download(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("expected the changed file to be downloaded from the start")
+	}
+}
+
+func TestDownloadResumeWithoutValidator(t *testing.T) {
+	data := testISO()
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	if err := os.WriteFile(dest, bytes.Repeat([]byte{1}, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDownloader().download(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || len(ranges) != 1 || ranges[0] != "" {
+		t.Fatalf("expected a full download, got ranges %q", ranges)
+	}
+}
+
+func TestDownloadStalled(t *testing.T) {
+	data := testISO()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:1000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	dl := testDownloader()
+	dl.idleTimeout = 50 * time.Millisecond
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	if err := dl.download(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected the stalled transfer to be retried, got %d requests", requests)
+	}
+	if err := validateISO(dest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(testISO())
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	if err := testDownloader().download(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests)
+	}
+	if err := validateISO(dest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	if err := testDownloader().download(srv.URL, dest); err == nil {
+		t.Fatal("expected 404 to fail the download")
+	}
+	if requests != 1 {
+		t.Fatalf("expected no retries for 404, got %d requests", requests)
+	}
+}
+
+func TestDownloadCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testISO())
+	}))
+	defer srv.Close()
+
+	dl, err := newDownloader("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(t.TempDir(), "boot2docker.iso.part")
+	err = dl.fetch(srv.URL, dest)
+	if err == nil {
+		t.Fatal("expected untrusted certificate to be rejected")
+	}
+	if !permanent(err) {
+		t.Fatalf("expected %v not to be retried", err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl, err = newDownloader(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(dest)
+	if err := dl.download(srv.URL, dest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, size, err := parseContentRange("bytes 100-199/200")
+	if err != nil || start != 100 || size != 200 {
+		t.Fatalf("got %d, %d, %v", start, size, err)
+	}
+	if _, _, err := parseContentRange("items 1-2/3"); err == nil {
+		t.Fatal("expected invalid unit to be rejected")
+	}
+}
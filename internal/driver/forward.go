@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// forwardHost is the host address ports of the emulated network are
+	// forwarded to.
+	forwardHost = "127.0.0.1"
+	// dockerPort is the port of the Docker daemon in the guest.
+	dockerPort = 2376
+)
+
+// forwarded reports whether the guest is reached through ports forwarded to
+// localhost. The emulated network is private to the VM, so it is the only way
+// in; machines created before forwards existed get theirs on the next start.
+func (d *Driver) forwarded() bool {
+	return d.primaryInterface() == 0 && d.Network == string(utm.QemuNetworkModeEmulated) &&
+		d.SSHHostPort != 0 && d.DockerHostPort != 0
+}
+
+// parsePortForward parses a forward in host:guest[/proto] form, where proto is
+// tcp (the default) or udp.
+func parsePortForward(s string) (utm.QemuPortForwardingConf, error) {
+	ports, proto, _ := strings.Cut(s, "/")
+	host, guest, ok := strings.Cut(ports, ":")
+	if !ok {
+		return utm.QemuPortForwardingConf{}, fmt.Errorf("invalid port forward %q: expected host:guest[/proto]", s)
+	}
+	conf := utm.QemuPortForwardingConf{HostAddr: forwardHost}
+	switch strings.ToLower(proto) {
+	case "", "tcp":
+		conf.Protocol = utm.QemuPortForwardingProtocolTCP
+	case "udp":
+		conf.Protocol = utm.QemuPortForwardingProtocolUDP
+	default:
+		return utm.QemuPortForwardingConf{}, fmt.Errorf("invalid port forward %q: unknown protocol %q", s, proto)
+	}
+	var err error
+	if conf.HostPort, err = parsePort(host); err != nil {
+		return utm.QemuPortForwardingConf{}, fmt.Errorf("invalid port forward %q: %w", s, err)
+	}
+	if conf.GuestPort, err = parsePort(guest); err != nil {
+		return utm.QemuPortForwardingConf{}, fmt.Errorf("invalid port forward %q: %w", s, err)
+	}
+	return conf, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// portForwards returns the forwards of the emulated network: SSH and Docker
+// to free localhost ports, which are picked once and then kept in the driver
+// config, followed by the extra forwards of --utm-port-forward. A kept port
+// that another process took meanwhile is replaced.
+func (d *Driver) portForwards() ([]utm.QemuPortForwardingConf, error) {
+	var extra []utm.QemuPortForwardingConf
+	used := map[int]bool{}
+	for _, s := range d.PortForwards {
+		forward, err := parsePortForward(s)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, forward)
+		used[forward.HostPort] = true
+	}
+
+	for _, port := range []*int{&d.SSHHostPort, &d.DockerHostPort} {
+		if *port != 0 && !used[*port] && !portFree(*port) {
+			log.Warnf("Forwarded port %d is in use by another process, picking a new one", *port)
+			*port = 0
+		}
+		for *port == 0 || used[*port] {
+			free, err := freePort()
+			if err != nil {
+				return nil, fmt.Errorf("allocating a forwarded port: %w", err)
+			}
+			*port = free
+		}
+		used[*port] = true
+	}
+
+	forwards := []utm.QemuPortForwardingConf{
+		{Protocol: utm.QemuPortForwardingProtocolTCP, HostAddr: forwardHost, HostPort: d.SSHHostPort, GuestPort: d.guestSSHPort()},
+		{Protocol: utm.QemuPortForwardingProtocolTCP, HostAddr: forwardHost, HostPort: d.DockerHostPort, GuestPort: dockerPort},
+	}
+	return append(forwards, extra...), nil
+}
+
+// guestSSHPort is the port sshd listens on in the guest.
+func (d *Driver) guestSSHPort() int {
+	if d.SSHPort == 0 {
+		return 22
+	}
+	return d.SSHPort
+}
+
+// freePort returns a TCP port of forwardHost that nothing listens on.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(forwardHost, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// portFree reports whether nothing listens on a TCP port of forwardHost.
+func portFree(port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(forwardHost, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
@@ -0,0 +1,162 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParsePortForward(t *testing.T) {
+	tests := []struct {
+		in   string
+		want utm.QemuPortForwardingConf
+		ok   bool
+	}{
+		{"8080:80", utm.QemuPortForwardingConf{Protocol: utm.QemuPortForwardingProtocolTCP, HostAddr: "127.0.0.1", HostPort: 8080, GuestPort: 80}, true},
+		{"8080:80/tcp", utm.QemuPortForwardingConf{Protocol: utm.QemuPortForwardingProtocolTCP, HostAddr: "127.0.0.1", HostPort: 8080, GuestPort: 80}, true},
+		{"5353:53/UDP", utm.QemuPortForwardingConf{Protocol: utm.QemuPortForwardingProtocolUDP, HostAddr: "127.0.0.1", HostPort: 5353, GuestPort: 53}, true},
+		{"8080", utm.QemuPortForwardingConf{}, false},
+		{"8080:80/sctp", utm.QemuPortForwardingConf{}, false},
+		{"0:80", utm.QemuPortForwardingConf{}, false},
+		{"8080:65536", utm.QemuPortForwardingConf{}, false},
+		{"http:80", utm.QemuPortForwardingConf{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parsePortForward(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parsePortForward(%q): unexpected error %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parsePortForward(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetConfigFromFlagsPortForward(t *testing.T) {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	flags := &fakeFlags{Data: map[string]interface{}{
+		"utm-backend":      "qemu",
+		"utm-network":      "shared",
+		"utm-port-forward": []string{"8080:80"},
+	}}
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected port forwards to require the emulated network")
+	}
+	flags.Data["utm-network"] = "emulated"
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	flags.Data["utm-port-forward"] = []string{"8080:80", "80"}
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected an invalid port forward to be rejected")
+	}
+}
+
+func TestDriverEmulatedEndpoints(t *testing.T) {
+	backend := utmtest.New()
+	d := newTestDriver(t, backend)
+	d.Boot2DockerURL = writeTestISO(t)
+	d.Network = string(utm.QemuNetworkModeEmulated)
+	d.PortForwards = []string{"8080:80", "5353:53/udp"}
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.SSHHostPort == 0 || d.DockerHostPort == 0 || d.SSHHostPort == d.DockerHostPort {
+		t.Fatalf("unexpected forwarded ports: ssh %d, docker %d", d.SSHHostPort, d.DockerHostPort)
+	}
+	vm, _ := backend.VM("docker-machine-test")
+	forwards := vm.Config.Networks[0].PortForwarding
+	if len(forwards) != 4 {
+		t.Fatalf("expected 4 forwards, got %+v", forwards)
+	}
+	if forwards[0].HostPort != d.SSHHostPort || forwards[0].GuestPort != 22 ||
+		forwards[1].HostPort != d.DockerHostPort || forwards[1].GuestPort != 2376 ||
+		forwards[3].Protocol != utm.QemuPortForwardingProtocolUDP || forwards[3].HostAddr != "127.0.0.1" {
+		t.Fatalf("unexpected forwards %+v", forwards)
+	}
+
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "127.0.0.1" || port != d.SSHHostPort {
+		t.Fatalf("unexpected ssh endpoint %s:%d", host, port)
+	}
+	url, err := d.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("tcp://127.0.0.1:%d", d.DockerHostPort); url != want {
+		t.Fatalf("expected %s, got %s", want, url)
+	}
+}
+
+func TestDriverStartAddsForwards(t *testing.T) {
+	backend := utmtest.New()
+	vm, err := backend.Client().CreateQemuVM(&utm.QemuConf{
+		Name:     "docker-machine-test",
+		Networks: []utm.QemuNetworkConf{{Mode: utm.QemuNetworkModeEmulated}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDriver(t, backend)
+	d.Network = string(utm.QemuNetworkModeEmulated)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := backend.Client().GetConfiguration(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Networks[0].PortForwarding) != 2 || conf.Networks[0].PortForwarding[0].HostPort != d.SSHHostPort {
+		t.Fatalf("unexpected forwards %+v", conf.Networks[0].PortForwarding)
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != d.SSHHostPort {
+		t.Fatalf("expected ssh port %d, got %d", d.SSHHostPort, port)
+	}
+}
+
+func TestDriverStartReplacesTakenPorts(t *testing.T) {
+	backend := utmtest.New()
+	d := newTestDriver(t, backend)
+	d.Boot2DockerURL = writeTestISO(t)
+	d.Network = string(utm.QemuNetworkModeEmulated)
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(d.SSHHostPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	taken := d.SSHHostPort
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if d.SSHHostPort == taken || d.SSHHostPort == d.DockerHostPort {
+		t.Fatalf("expected a new ssh port, got %d", d.SSHHostPort)
+	}
+	vm, _ := backend.VM("docker-machine-test")
+	if forwards := vm.Config.Networks[0].PortForwarding; forwards[0].HostPort != d.SSHHostPort {
+		t.Fatalf("unexpected forwards %+v", forwards)
+	}
+}
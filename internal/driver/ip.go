@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"net/netip"
+)
+
+// dockerNetworks are the default address pools of Docker bridges (docker0 and
+// user-defined networks), which the host cannot reach.
+var dockerNetworks = []netip.Prefix{
+	netip.MustParsePrefix("172.17.0.0/16"),
+	netip.MustParsePrefix("172.18.0.0/15"),
+	netip.MustParsePrefix("172.20.0.0/14"),
+	netip.MustParsePrefix("172.24.0.0/13"),
+}
+
+// selectIP picks the address the host should use to reach the guest among
+// those reported by the guest agent. When cidr is valid, only addresses in it
+// are considered. Otherwise routable IPv4 addresses are preferred over IPv6
+// ones, and loopback, link-local and Docker bridge addresses are skipped. It
+// returns "" when no address qualifies yet.
+func selectIP(ips []string, cidr netip.Prefix) string {
+	var v6 string
+	for _, ip := range ips {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		if cidr.IsValid() {
+			if cidr.Contains(addr.WithZone("").Unmap()) {
+				return ip
+			}
+			continue
+		}
+		if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() || addr.IsMulticast() || inDockerNetwork(addr) {
+			continue
+		}
+		if addr.Is4() || addr.Is4In6() {
+			return addr.Unmap().String()
+		}
+		if v6 == "" {
+			v6 = ip
+		}
+	}
+	return v6
+}
+
+func inDockerNetwork(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, network := range dockerNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
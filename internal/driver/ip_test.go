@@ -0,0 +1,75 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"net/netip"
+	"testing"
+)
+
+func TestSelectIP(t *testing.T) {
+	tests := []struct {
+		ips  []string
+		cidr string
+		want string
+	}{
+		{[]string{"192.168.64.2"}, "", "192.168.64.2"},
+		{[]string{"fe80::1%eth0", "172.17.0.1", "192.168.64.2"}, "", "192.168.64.2"},
+		{[]string{"127.0.0.1", "169.254.1.1", "172.18.0.1", "fd00::2", "10.0.0.5"}, "", "10.0.0.5"},
+		{[]string{"fe80::1%eth0", "2001:db8::5", "fd00::2"}, "", "2001:db8::5"},
+		{[]string{"fe80::1%eth0", "172.17.0.1"}, "", ""},
+		{[]string{"::ffff:192.168.64.3"}, "", "192.168.64.3"},
+		{[]string{"192.168.64.2", "10.0.0.5"}, "10.0.0.0/8", "10.0.0.5"},
+		{[]string{"192.168.64.2", "172.17.0.1"}, "172.17.0.0/16", "172.17.0.1"},
+		{[]string{"192.168.64.2"}, "10.0.0.0/8", ""},
+		{[]string{"not an ip", "192.168.64.2"}, "", "192.168.64.2"},
+		{nil, "", ""},
+	}
+	for _, tt := range tests {
+		var cidr netip.Prefix
+		if tt.cidr != "" {
+			cidr = netip.MustParsePrefix(tt.cidr)
+		}
+		if got := selectIP(tt.ips, cidr); got != tt.want {
+			t.Errorf("selectIP(%v, %q) = %q, want %q", tt.ips, tt.cidr, got, tt.want)
+		}
+	}
+}
+
+func TestDriverIPv6URL(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+	backend.SetIPs("docker-machine-test", "fe80::1", "2001:db8::5")
+	d := newTestDriver(t, backend)
+
+	url, err := d.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "tcp://[2001:db8::5]:2376" {
+		t.Fatalf("unexpected url %s", url)
+	}
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "2001:db8::5" {
+		t.Fatalf("unexpected ssh hostname %s", host)
+	}
+}
+
+func TestSetConfigFromFlagsIPCIDR(t *testing.T) {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	flags := &fakeFlags{Data: map[string]interface{}{
+		"utm-backend": "qemu",
+		"utm-network": "shared",
+		"utm-ip-cidr": "192.168.64.0/33",
+	}}
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected invalid CIDR to be rejected")
+	}
+	flags.Data["utm-ip-cidr"] = "192.168.64.0/24"
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+}
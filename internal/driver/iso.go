@@ -0,0 +1,215 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// ISO 9660 images carry this identifier in the first volume descriptor.
+const (
+	isoMagicOffset = 0x8001
+	isoMagic       = "CD001"
+)
+
+// isoLockTimeout bounds the wait for another process downloading the same
+// image.
+const isoLockTimeout = 30 * time.Minute
+
+// hostArch is the architecture of the host, which the apple backend can only
+// virtualize. The default ISO is built for amd64.
+var hostArch = runtime.GOARCH
+
+func (d *Driver) isoURL() string {
+	if d.Boot2DockerURL != "" {
+		return d.Boot2DockerURL
+	}
+	return B2dURL
+}
+
+func (d *Driver) isoCacheDir() string {
+	return filepath.Join(d.StorePath, "cache", "utm")
+}
+
+// prepareISO places a verified boot2docker ISO in the machine directory.
+// Downloaded images are kept in a cache shared by every machine.
+func (d *Driver) prepareISO() error {
+	dl, err := newDownloader(d.CABundle)
+	if err != nil {
+		return err
+	}
+	src, err := cachedBoot2DockerISO(dl, d.isoCacheDir(), d.isoURL(), d.Boot2DockerSHA256)
+	if err != nil {
+		return err
+	}
+	return installISO(src, d.ResolveStorePath(IsoFilename))
+}
+
+// cachedBoot2DockerISO returns the path of a verified copy of src. http(s)
+// URLs are downloaded into cacheDir once, keyed by checksum when one is
+// given and by URL otherwise. file:// URLs and local paths are used in place.
+func cachedBoot2DockerISO(dl *downloader, cacheDir, src, sum string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("invalid boot2docker url %q: %w", src, err)
+	}
+
+	var path string
+	switch u.Scheme {
+	case "http", "https":
+	case "file":
+		path = u.Path
+	case "":
+		path = src
+	default:
+		return "", fmt.Errorf("unsupported boot2docker url scheme: %s", u.Scheme)
+	}
+	if path != "" {
+		path, err = filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		if err := verifyISO(path, sum); err != nil {
+			return "", fmt.Errorf("%s: %w", src, err)
+		}
+		return path, nil
+	}
+
+	path = filepath.Join(cacheDir, isoCacheKey(src, sum))
+	if _, err := os.Stat(path); err == nil {
+		if err := verifyISO(path, sum); err == nil {
+			log.Infof("Using cached ISO %s", path)
+			return path, nil
+		}
+		log.Warnf("Cached ISO %s is invalid, downloading it again", path)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	// Concurrent creates share the cache, so only one of them downloads.
+	unlock, err := lockFile(path+".lock", isoLockTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	if err := verifyISO(path, sum); err == nil {
+		log.Infof("Using ISO %s downloaded by another process", path)
+		return path, nil
+	}
+
+	// The partial file is kept on failure so the next attempt can resume.
+	part := path + ".part"
+	log.Infof("Downloading %s...", src)
+	if err := dl.download(src, part); err != nil {
+		return "", err
+	}
+	if err := verifyISO(part, sum); err != nil {
+		os.Remove(part)
+		return "", fmt.Errorf("%s: %w", src, err)
+	}
+
+	if err := os.Rename(part, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func isoCacheKey(src, sum string) string {
+	if sum != "" {
+		return fmt.Sprintf("boot2docker-sha256-%s.iso", sum)
+	}
+	h := sha256.Sum256([]byte(src))
+	return fmt.Sprintf("boot2docker-url-%s.iso", hex.EncodeToString(h[:16]))
+}
+
+// installISO links src into place at dest, falling back to a copy when the
+// cache lives on a different volume.
+func installISO(src, dest string) error {
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".iso-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func verifyISO(path, sum string) error {
+	if err := validateISO(path); err != nil {
+		return err
+	}
+	if sum == "" {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", sum, actual)
+	}
+	return nil
+}
+
+func validateISO(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(isoMagic))
+	if _, err := f.ReadAt(magic, isoMagicOffset); err != nil || !bytes.Equal(magic, []byte(isoMagic)) {
+		return fmt.Errorf("not an ISO 9660 image")
+	}
+	return nil
+}
+
+func normalizeSHA256(sum string) (string, error) {
+	sum = strings.ToLower(strings.TrimSpace(sum))
+	if sum == "" {
+		return "", nil
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 checksum: %s", sum)
+	}
+	return sum, nil
+}
@@ -0,0 +1,189 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testISO() []byte {
+	data := make([]byte, isoMagicOffset+2048)
+	copy(data[isoMagicOffset:], isoMagic)
+	return data
+}
+
+func testISOSum() string {
+	h := sha256.Sum256(testISO())
+	return hex.EncodeToString(h[:])
+}
+
+func testDownloader() *downloader {
+	return &downloader{client: http.DefaultClient, retries: 2, backoff: time.Millisecond}
+}
+
+func writeTestISO(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "boot2docker.iso")
+	if err := os.WriteFile(path, testISO(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCachedBoot2DockerISOLocal(t *testing.T) {
+	iso := writeTestISO(t)
+	cacheDir := t.TempDir()
+
+	for _, src := range []string{iso, "file://" + iso} {
+		path, err := cachedBoot2DockerISO(testDownloader(), cacheDir, src, testISOSum())
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if path != iso {
+			t.Fatalf("%s: expected local image to be used in place, got %s", src, path)
+		}
+	}
+
+	if _, err := cachedBoot2DockerISO(testDownloader(), cacheDir, iso, testISOSum()[1:]+"0"); err == nil {
+		t.Fatal("expected checksum mismatch to be rejected")
+	}
+	if _, err := cachedBoot2DockerISO(testDownloader(), cacheDir, "ftp://example.com/boot2docker.iso", ""); err == nil {
+		t.Fatal("expected unsupported scheme to be rejected")
+	}
+}
+
+func TestCachedBoot2DockerISODownload(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/index.html" {
+			w.Write([]byte("<html>not found</html>"))
+			return
+		}
+		w.Write(testISO())
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	for i := 0; i < 3; i++ {
+		path, err := cachedBoot2DockerISO(testDownloader(), cacheDir, srv.URL+"/boot2docker.iso", testISOSum())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(path) != cacheDir {
+			t.Fatalf("expected image in cache, got %s", path)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single download, got %d", requests)
+	}
+
+	if _, err := cachedBoot2DockerISO(testDownloader(), cacheDir, srv.URL+"/index.html", ""); err == nil {
+		t.Fatal("expected non-ISO content to be rejected")
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the verified image in the cache, got %d entries", len(entries))
+	}
+}
+
+func TestCachedBoot2DockerISOConcurrent(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write(testISO())
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cachedBoot2DockerISO(testDownloader(), cacheDir, srv.URL+"/boot2docker.iso", testISOSum())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single download, got %d", requests)
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the image in the cache, got %d entries", len(entries))
+	}
+}
+
+func TestLockFileStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iso.lock")
+	unlock, err := lockFile(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockFile(path, 300*time.Millisecond); err == nil {
+		t.Fatal("expected a held lock to time out")
+	}
+	unlock()
+
+	// A pid that cannot be running.
+	if err := os.WriteFile(path, []byte("2147483647"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = lockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("expected a stale lock to be broken: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock to be released, got %v", err)
+	}
+}
+
+func TestPrepareISO(t *testing.T) {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatal(err)
+	}
+	d.Boot2DockerURL = writeTestISO(t)
+
+	if err := d.prepareISO(); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateISO(d.ResolveStorePath(IsoFilename)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNormalizeSHA256(t *testing.T) {
+	sum, err := normalizeSHA256(" " + "ABCDEF" + testISOSum()[6:] + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sum) != 64 || sum[:6] != "abcdef" {
+		t.Fatalf("unexpected checksum: %s", sum)
+	}
+	if _, err := normalizeSHA256("abc"); err == nil {
+		t.Fatal("expected short checksum to be rejected")
+	}
+}
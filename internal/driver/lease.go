@@ -0,0 +1,218 @@
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"docker-machine-driver-utm/pkg/utm"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+var (
+	// dhcpLeasesPath is where the macOS DHCP server used by vmnet (shared and
+	// host networks) records its leases.
+	dhcpLeasesPath = "/var/db/dhcpd_leases"
+	// arpTable returns the output of `arp -an`.
+	arpTable = func() ([]byte, error) {
+		return exec.Command("arp", "-an").Output()
+	}
+)
+
+// dhcpLease is an entry of the DHCP lease file.
+type dhcpLease struct {
+	Name  string
+	IP    string
+	MAC   net.HardwareAddr
+	Lease int64
+}
+
+// parseDHCPLeases reads the lease file of the macOS DHCP server, a list of
+// blocks such as:
+//
+//	{
+//		name=boot2docker
+//		ip_address=192.168.64.2
+//		hw_address=1,52:54:0:12:34:56
+//		lease=0x65a1b2c3
+//	}
+//
+// The hardware address is prefixed with its type and drops leading zeros.
+func parseDHCPLeases(r io.Reader) ([]dhcpLease, error) {
+	var leases []dhcpLease
+	var cur *dhcpLease
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+		case text == "{":
+			cur = &dhcpLease{}
+		case text == "}":
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: unexpected }", line)
+			}
+			if cur.IP != "" && cur.MAC != nil {
+				leases = append(leases, *cur)
+			}
+			cur = nil
+		case cur == nil:
+			return nil, fmt.Errorf("line %d: expected {", line)
+		default:
+			key, value, ok := strings.Cut(text, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value", line)
+			}
+			switch key {
+			case "name":
+				cur.Name = value
+			case "ip_address":
+				cur.IP = value
+			case "hw_address":
+				_, addr, _ := strings.Cut(value, ",")
+				mac, err := parseMAC(addr)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				cur.MAC = mac
+			case "lease":
+				lease, err := strconv.ParseInt(strings.TrimPrefix(value, "0x"), 16, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid lease %q", line, value)
+				}
+				cur.Lease = lease
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		return nil, fmt.Errorf("unterminated lease")
+	}
+	return leases, nil
+}
+
+// arpEntry matches the lines of `arp -an`, such as
+// `? (192.168.64.2) at 52:54:0:12:34:56 on bridge100 ifscope [ethernet]`.
+var arpEntry = regexp.MustCompile(`^\S+ \(([^)]+)\) at ([0-9a-fA-F:]+) on `)
+
+// parseARP maps the complete entries of `arp -an` from MAC to IP address.
+func parseARP(r io.Reader) map[string]string {
+	entries := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := arpEntry.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		mac, err := parseMAC(m[2])
+		if err != nil {
+			continue
+		}
+		entries[mac.String()] = m[1]
+	}
+	return entries
+}
+
+// lookupIPByMAC resolves the address of a guest from its MAC address, using
+// the most recent DHCP lease and then the ARP table. It returns "" when
+// neither knows the address.
+func lookupIPByMAC(mac net.HardwareAddr) (string, error) {
+	data, err := os.ReadFile(dhcpLeasesPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	leases, err := parseDHCPLeases(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("parsing %s: %w", dhcpLeasesPath, err)
+	}
+	var best *dhcpLease
+	for i, lease := range leases {
+		if bytes.Equal(lease.MAC, mac) && (best == nil || lease.Lease > best.Lease) {
+			best = &leases[i]
+		}
+	}
+	if best != nil {
+		return best.IP, nil
+	}
+
+	out, err := arpTable()
+	if err != nil {
+		return "", fmt.Errorf("reading ARP table: %w", err)
+	}
+	return parseARP(bytes.NewReader(out))[mac.String()], nil
+}
+
+// leaseIP looks the MAC address of the primary interface up in the DHCP
+// leases and the ARP table. Emulated networks are private to the VM, so it
+// cannot help there.
+func (d *Driver) leaseIP() string {
+	if d.primaryMode() == string(utm.QemuNetworkModeEmulated) {
+		return ""
+	}
+	mac, err := d.macAddress()
+	if err != nil || mac == nil {
+		log.Debugf("Cannot resolve the IP address without the MAC address: %v", err)
+		return ""
+	}
+	ip, err := lookupIPByMAC(mac)
+	if err != nil {
+		log.Debugf("Looking up the IP address of %s: %v", mac, err)
+		return ""
+	}
+	return ip
+}
+
+// macAddress returns the MAC address of the primary interface: the one the
+// driver assigned, or else the one in its UTM configuration.
+func (d *Driver) macAddress() (net.HardwareAddr, error) {
+	if d.mac != nil {
+		return d.mac, nil
+	}
+	index := d.primaryInterface()
+	addr := d.MACAddress
+	if index > 0 {
+		addr = d.NetworkInterfaces[index-1].MAC
+	}
+	switch {
+	case addr != "":
+	case d.VM.Backend == utm.VmBackendApple:
+		conf, err := d.utmClient().GetAppleConfiguration(d.VM)
+		if err != nil {
+			return nil, err
+		}
+		for _, network := range conf.Networks {
+			if network.Index == index {
+				addr = network.MAC
+			}
+		}
+	default:
+		conf, err := d.utmClient().GetConfiguration(d.VM)
+		if err != nil {
+			return nil, err
+		}
+		for _, network := range conf.Networks {
+			if network.Index == index {
+				addr = network.MAC
+			}
+		}
+	}
+	if addr == "" {
+		return nil, nil
+	}
+	mac, err := parseMAC(addr)
+	if err != nil {
+		return nil, err
+	}
+	d.mac = mac
+	return mac, nil
+}
@@ -0,0 +1,114 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func useLeaseFixtures(t *testing.T) {
+	path, table := dhcpLeasesPath, arpTable
+	t.Cleanup(func() { dhcpLeasesPath, arpTable = path, table })
+	dhcpLeasesPath = "testdata/dhcpd_leases"
+	arpTable = func() ([]byte, error) {
+		return os.ReadFile("testdata/arp.txt")
+	}
+}
+
+func TestParseDHCPLeases(t *testing.T) {
+	f, err := os.Open("testdata/dhcpd_leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	leases, err := parseDHCPLeases(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 3 {
+		t.Fatalf("expected 3 leases, got %+v", leases)
+	}
+	last := leases[2]
+	if last.Name != "ubuntu" || last.IP != "192.168.64.3" || last.MAC.String() != "a6:03:e1:4c:0b:2f" || last.Lease != 0x65a0ffff {
+		t.Fatalf("unexpected lease %+v", last)
+	}
+
+	for _, bad := range []string{
+		"name=orphan\n",
+		"{\nip_address=192.168.64.2\n",
+		"}\n",
+		"{\nhw_address=1,52:54:0:0:1\n}\n",
+		"{\nlease=soon\n}\n",
+	} {
+		if _, err := parseDHCPLeases(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestParseARP(t *testing.T) {
+	f, err := os.Open("testdata/arp.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entries := parseARP(f)
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %v", entries)
+	}
+	if ip := entries["52:54:00:00:00:02"]; ip != "192.168.64.9" {
+		t.Fatalf("unexpected ip %q", ip)
+	}
+}
+
+func TestDriverLeaseIP(t *testing.T) {
+	useLeaseFixtures(t)
+	tests := []struct {
+		mac  string
+		mode utm.QemuNetworkMode
+		want string
+	}{
+		{"52:54:00:00:00:01", utm.QemuNetworkModeShared, "192.168.64.7"},
+		{"52:54:00:00:00:02", utm.QemuNetworkModeShared, "192.168.64.9"},
+		{"52:54:00:00:00:03", utm.QemuNetworkModeShared, ""},
+		// The emulated network has no leases, the guest is reached through
+		// the forwards.
+		{"52:54:00:00:00:01", utm.QemuNetworkModeEmulated, "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		backend := utmtest.New()
+		_, err := backend.Client().CreateQemuVM(&utm.QemuConf{
+			Name:     "docker-machine-test",
+			Networks: []utm.QemuNetworkConf{{Mode: tt.mode, MAC: tt.mac}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend.SetGuestAgent("docker-machine-test", false)
+
+		d := newTestDriver(t, backend)
+		d.Network = string(tt.mode)
+		d.StartTimeout = 1
+		err = d.Start()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s on %s: expected start to time out", tt.mac, tt.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s on %s: %v", tt.mac, tt.mode, err)
+		}
+		ip, err := d.GetIP()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip != tt.want {
+			t.Errorf("%s on %s: expected %s, got %s", tt.mac, tt.mode, tt.want, ip)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	lockPollInterval = 200 * time.Millisecond
+	// lockStaleAge is how long a lock file may stay empty before it is
+	// considered left behind by a process that died while creating it.
+	lockStaleAge = time.Minute
+)
+
+// lockFile takes an exclusive lock by creating path, which holds the pid of
+// the owner. It waits up to timeout while another live process holds the
+// lock, and breaks locks whose owner is gone. The returned function releases
+// the lock.
+func lockFile(path string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if lockStale(path) {
+			log.Debugf("Breaking stale lock %s", path)
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", path)
+		}
+		if !waiting {
+			log.Infof("Waiting for another process to release %s...", path)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lockStale reports whether the owner of a lock file is no longer running.
+func lockStale(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Released meanwhile; the next attempt takes it.
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		fi, err := os.Stat(path)
+		return err == nil && time.Since(fi.ModTime()) > lockStaleAge
+	}
+	err = syscall.Kill(pid, 0)
+	return errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// machineMAC derives a MAC address from the machine name, so a machine that
+// is recreated under the same name gets the same DHCP lease. The address is
+// unicast and locally administered, and cannot clash with real hardware.
+func machineMAC(name string) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(name))
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac
+}
+
+// parseMAC parses a colon separated MAC address whose octets may lack their
+// leading zero, as macOS prints them.
+func parseMAC(s string) (net.HardwareAddr, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q", s)
+	}
+	mac := make(net.HardwareAddr, 6)
+	for i, part := range parts {
+		b, err := strconv.ParseUint(part, 16, 8)
+		if err != nil || part == "" {
+			return nil, fmt.Errorf("invalid MAC address %q", s)
+		}
+		mac[i] = byte(b)
+	}
+	return mac, nil
+}
+
+// sameMAC reports whether two MAC addresses are equal, whatever their case
+// and padding.
+func sameMAC(a, b string) bool {
+	x, err := parseMAC(a)
+	if err != nil {
+		return false
+	}
+	y, err := parseMAC(b)
+	return err == nil && x.String() == y.String()
+}
@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"testing"
+)
+
+func TestMachineMAC(t *testing.T) {
+	mac := machineMAC("default")
+	if mac.String() != machineMAC("default").String() {
+		t.Fatal("expected the same MAC address for the same name")
+	}
+	if mac.String() == machineMAC("other").String() {
+		t.Fatal("expected different MAC addresses for different names")
+	}
+	if mac[0]&0x01 != 0 || mac[0]&0x02 == 0 {
+		t.Fatalf("expected a unicast, locally administered address, got %s", mac)
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"52:54:0:12:34:56", "52:54:00:12:34:56"},
+		{"52:54:00:12:34:56", "52:54:00:12:34:56"},
+		{"A6:3:E1:4C:B:2F", "a6:03:e1:4c:0b:2f"},
+		{"52:54:0:12:34", ""},
+		{"52:54::12:34:56", ""},
+		{"52:54:0:12:34:567", ""},
+	}
+	for _, tt := range tests {
+		mac, err := parseMAC(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseMAC(%q) = %s, want an error", tt.in, mac)
+			}
+			continue
+		}
+		if err != nil || mac.String() != tt.want {
+			t.Errorf("parseMAC(%q) = %s, %v, want %s", tt.in, mac, err, tt.want)
+		}
+	}
+}
+
+func TestSetConfigFromFlagsMAC(t *testing.T) {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	flags := &fakeFlags{Data: map[string]interface{}{
+		"utm-backend": "qemu",
+		"utm-network": "shared",
+	}}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	if d.MACAddress != machineMAC("test").String() {
+		t.Fatalf("expected the derived MAC address, got %s", d.MACAddress)
+	}
+
+	flags.Data["utm-mac-address"] = "52:54:0:AB:cd:1"
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	if d.MACAddress != "52:54:00:ab:cd:01" {
+		t.Fatalf("unexpected MAC address %s", d.MACAddress)
+	}
+
+	for _, bad := range []string{"52:54:00:ab:cd", "01:00:5e:00:00:fb"} {
+		flags.Data["utm-mac-address"] = bad
+		if err := d.SetConfigFromFlags(flags); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestDriverRecreateKeepsMAC(t *testing.T) {
+	backend := utmtest.New()
+	for i := 0; i < 2; i++ {
+		d := newTestDriver(t, backend)
+		d.Boot2DockerURL = writeTestISO(t)
+		d.MACAddress = machineMAC(d.MachineName).String()
+		if err := d.Create(); err != nil {
+			t.Fatal(err)
+		}
+		vm, ok := backend.VM("docker-machine-test")
+		if !ok {
+			t.Fatal("expected vm to be created")
+		}
+		if len(vm.Config.Networks) != 1 || vm.Config.Networks[0].MAC != d.MACAddress {
+			t.Fatalf("expected MAC address %s, got %+v", d.MACAddress, vm.Config.Networks)
+		}
+		if err := d.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDriverStartAppliesMAC(t *testing.T) {
+	backend := utmtest.New()
+	vm, err := backend.Client().CreateQemuVM(&utm.QemuConf{
+		Name:     "docker-machine-test",
+		Networks: []utm.QemuNetworkConf{{Mode: utm.QemuNetworkModeShared}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDriver(t, backend)
+	d.MACAddress = "02:00:00:00:00:01"
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := backend.Client().GetConfiguration(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].MAC != "02:00:00:00:00:01" || conf.Networks[0].Mode != utm.QemuNetworkModeShared {
+		t.Fatalf("unexpected networks: %+v", conf.Networks)
+	}
+}
@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// NetworkInterface is a network interface added with --utm-network-interface,
+// after the one of --utm-network.
+type NetworkInterface struct {
+	Mode          string
+	HostInterface string
+	MAC           string
+	Hardware      string
+	// Primary makes the driver reach the guest through this interface
+	// instead of the first one.
+	Primary bool
+}
+
+// parseNetworkInterface parses an interface in
+// mode[:hostIface][,mac=..][,hardware=..][,primary] form.
+func parseNetworkInterface(s string) (NetworkInterface, error) {
+	fields := strings.Split(s, ",")
+	var nic NetworkInterface
+	nic.Mode, nic.HostInterface, _ = strings.Cut(fields[0], ":")
+	switch utm.QemuNetworkMode(nic.Mode) {
+	case utm.QemuNetworkModeEmulated, utm.QemuNetworkModeShared, utm.QemuNetworkModeHost:
+		if nic.HostInterface != "" {
+			return NetworkInterface{}, fmt.Errorf("invalid network interface %q: only bridged interfaces take a host interface", s)
+		}
+	case utm.QemuNetworkModeBridged:
+	default:
+		return NetworkInterface{}, fmt.Errorf("invalid network interface %q: unknown mode %q", s, nic.Mode)
+	}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "mac":
+			mac, err := parseMAC(value)
+			if err != nil {
+				return NetworkInterface{}, fmt.Errorf("invalid network interface %q: %w", s, err)
+			}
+			nic.MAC = mac.String()
+		case "hardware":
+			if value == "" {
+				return NetworkInterface{}, fmt.Errorf("invalid network interface %q: empty hardware", s)
+			}
+			nic.Hardware = value
+		case "primary":
+			nic.Primary = true
+		default:
+			return NetworkInterface{}, fmt.Errorf("invalid network interface %q: unknown option %q", s, key)
+		}
+	}
+	if nic.Primary && nic.Mode == string(utm.QemuNetworkModeEmulated) {
+		return NetworkInterface{}, fmt.Errorf("invalid network interface %q: an emulated interface cannot be primary", s)
+	}
+	return nic, nil
+}
+
+// primaryInterface returns the index of the interface the driver reaches the
+// guest through: the one marked primary, or else the first one.
+func (d *Driver) primaryInterface() int {
+	for i, nic := range d.NetworkInterfaces {
+		if nic.Primary {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// primaryMode returns the network mode of the primary interface.
+func (d *Driver) primaryMode() string {
+	if i := d.primaryInterface(); i > 0 {
+		return d.NetworkInterfaces[i-1].Mode
+	}
+	return d.Network
+}
+
+// qemuNetworks returns the network interfaces of a QEMU VM, in UTM's order.
+func (d *Driver) qemuNetworks() ([]utm.QemuNetworkConf, error) {
+	first := utm.QemuNetworkConf{Mode: utm.QemuNetworkMode(d.Network), MAC: d.MACAddress}
+	switch first.Mode {
+	case utm.QemuNetworkModeBridged:
+		first.HostInterface = d.HostInterface
+	case utm.QemuNetworkModeEmulated:
+		forwards, err := d.portForwards()
+		if err != nil {
+			return nil, err
+		}
+		first.PortForwarding = forwards
+	}
+	networks := []utm.QemuNetworkConf{first}
+	for i, nic := range d.NetworkInterfaces {
+		networks = append(networks, utm.QemuNetworkConf{
+			Index:         i + 1,
+			Mode:          utm.QemuNetworkMode(nic.Mode),
+			HostInterface: nic.HostInterface,
+			MAC:           nic.MAC,
+			Hardware:      nic.Hardware,
+		})
+	}
+	return networks, nil
+}
+
+// appleNetworks returns the network interfaces of an Apple VM, in UTM's order.
+func (d *Driver) appleNetworks() []utm.AppleNetworkConf {
+	first := utm.AppleNetworkConf{Mode: utm.AppleNetworkMode(d.Network), MAC: d.MACAddress}
+	if first.Mode == utm.AppleNetworkModeBridged {
+		first.HostInterface = d.HostInterface
+	}
+	networks := []utm.AppleNetworkConf{first}
+	for i, nic := range d.NetworkInterfaces {
+		networks = append(networks, utm.AppleNetworkConf{
+			Index:         i + 1,
+			Mode:          utm.AppleNetworkMode(nic.Mode),
+			HostInterface: nic.HostInterface,
+			MAC:           nic.MAC,
+		})
+	}
+	return networks
+}
+
+// qemuNetworkChanged reports whether an interface differs from the one the
+// driver wants. Empty addresses, hardware and forwards are left to UTM.
+func qemuNetworkChanged(current, want utm.QemuNetworkConf) bool {
+	return current.Mode != want.Mode || current.HostInterface != want.HostInterface ||
+		(want.MAC != "" && !sameMAC(current.MAC, want.MAC)) ||
+		(want.Hardware != "" && current.Hardware != want.Hardware) ||
+		(want.PortForwarding != nil && !slices.Equal(current.PortForwarding, want.PortForwarding))
+}
+
+// appleNetworkChanged is qemuNetworkChanged for Apple VMs.
+func appleNetworkChanged(current, want utm.AppleNetworkConf) bool {
+	return current.Mode != want.Mode || current.HostInterface != want.HostInterface ||
+		(want.MAC != "" && !sameMAC(current.MAC, want.MAC))
+}
@@ -0,0 +1,166 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseNetworkInterface(t *testing.T) {
+	tests := []struct {
+		in   string
+		want NetworkInterface
+		ok   bool
+	}{
+		{"shared", NetworkInterface{Mode: "shared"}, true},
+		{"bridged:en0", NetworkInterface{Mode: "bridged", HostInterface: "en0"}, true},
+		{"host,mac=2:0:0:0:0:1,hardware=virtio-net-pci,primary", NetworkInterface{Mode: "host", MAC: "02:00:00:00:00:01", Hardware: "virtio-net-pci", Primary: true}, true},
+		{"bridged:en0,primary", NetworkInterface{Mode: "bridged", HostInterface: "en0", Primary: true}, true},
+		{"nat", NetworkInterface{}, false},
+		{"shared:en0", NetworkInterface{}, false},
+		{"host,mac=02:00:00:00:01", NetworkInterface{}, false},
+		{"host,hardware=", NetworkInterface{}, false},
+		{"host,speed=10", NetworkInterface{}, false},
+		{"emulated,primary", NetworkInterface{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseNetworkInterface(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseNetworkInterface(%q): unexpected error %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseNetworkInterface(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetConfigFromFlagsNetworkInterfaces(t *testing.T) {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	flags := &fakeFlags{Data: map[string]interface{}{
+		"utm-backend":           "qemu",
+		"utm-network":           "shared",
+		"utm-network-interface": []string{"host", "bridged:en0,mac=02:00:00:00:00:01"},
+	}}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.NetworkInterfaces) != 2 {
+		t.Fatalf("unexpected interfaces %+v", d.NetworkInterfaces)
+	}
+	mac := d.NetworkInterfaces[0].MAC
+	if mac == "" || mac == d.MACAddress || mac != machineMAC("test/1").String() {
+		t.Fatalf("expected a derived MAC address distinct from the first interface, got %s", mac)
+	}
+	if d.NetworkInterfaces[1].MAC != "02:00:00:00:00:01" {
+		t.Fatalf("unexpected MAC address %s", d.NetworkInterfaces[1].MAC)
+	}
+
+	for _, tc := range []struct {
+		backend    string
+		interfaces []string
+	}{
+		{"qemu", []string{"host,primary", "bridged:en0,primary"}},
+		{"apple", []string{"host"}},
+		{"apple", []string{"shared,hardware=e1000"}},
+	} {
+		flags.Data["utm-backend"] = tc.backend
+		flags.Data["utm-network-interface"] = tc.interfaces
+		if err := d.SetConfigFromFlags(flags); err == nil {
+			t.Errorf("expected %v to be rejected on %s", tc.interfaces, tc.backend)
+		}
+	}
+}
+
+func TestDriverPrimaryInterface(t *testing.T) {
+	useLeaseFixtures(t)
+	leases := filepath.Join(t.TempDir(), "dhcpd_leases")
+	if err := os.WriteFile(leases, []byte("{\n\tname=boot2docker\n\tip_address=192.168.128.3\n\thw_address=1,2:0:0:0:0:2\n\tlease=0x65a1c000\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dhcpLeasesPath = leases
+
+	backend := utmtest.New()
+	d := newTestDriver(t, backend)
+	d.Boot2DockerURL = writeTestISO(t)
+	d.MACAddress = "02:00:00:00:00:01"
+	d.NetworkInterfaces = []NetworkInterface{
+		{Mode: "host", MAC: "02:00:00:00:00:02", Hardware: "virtio-net-pci", Primary: true},
+	}
+	// Start waits for the primary interface, which the guest reports after
+	// the first one.
+	go func() {
+		for {
+			if _, ok := backend.VM("docker-machine-test"); ok {
+				backend.SetIPs("docker-machine-test", "192.168.64.2", "192.168.128.3")
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	vm, _ := backend.VM("docker-machine-test")
+	networks := vm.Config.Networks
+	if len(networks) != 2 || networks[0].Mode != utm.QemuNetworkModeShared || networks[0].MAC != "02:00:00:00:00:01" ||
+		networks[1].Mode != utm.QemuNetworkModeHost || networks[1].MAC != "02:00:00:00:00:02" || networks[1].Hardware != "virtio-net-pci" {
+		t.Fatalf("unexpected networks %+v", networks)
+	}
+
+	ip, err := d.GetIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "192.168.128.3" {
+		t.Fatalf("expected the address of the primary interface, got %s", ip)
+	}
+
+	// The address of another interface is never used instead.
+	backend.SetIPs("docker-machine-test", "192.168.64.2")
+	ip, err = d.GetIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "" {
+		t.Fatalf("expected no address until the primary interface has one, got %s", ip)
+	}
+
+	backend.SetIPs("docker-machine-test", "192.168.64.2", "192.168.128.3")
+	ip, err = d.GetIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "192.168.128.3" {
+		t.Fatalf("expected the address of the primary interface, got %s", ip)
+	}
+}
+
+func TestDriverStartAddsInterfaces(t *testing.T) {
+	backend := utmtest.New()
+	vm, err := backend.Client().CreateQemuVM(&utm.QemuConf{
+		Name:     "docker-machine-test",
+		Networks: []utm.QemuNetworkConf{{Mode: utm.QemuNetworkModeShared}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDriver(t, backend)
+	d.NetworkInterfaces = []NetworkInterface{{Mode: "bridged", HostInterface: "en0", MAC: "02:00:00:00:00:02"}}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := backend.Client().GetConfiguration(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Networks) != 2 || conf.Networks[1].Mode != utm.QemuNetworkModeBridged ||
+		conf.Networks[1].HostInterface != "en0" || conf.Networks[1].MAC != "02:00:00:00:00:02" {
+		t.Fatalf("unexpected networks %+v", conf.Networks)
+	}
+}
@@ -0,0 +1,42 @@
+package driver
+
+import (
+	"os"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// rollback records how to undo each resource created so far, so a failed
+// Create can clean up after itself in reverse order.
+type rollback struct {
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	name string
+	undo func() error
+}
+
+func (r *rollback) add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+func (r *rollback) run() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		log.Debugf("Removing %s...", step.name)
+		if err := step.undo(); err != nil {
+			log.Warnf("Failed to remove %s: %v", step.name, err)
+		}
+	}
+	r.steps = nil
+}
+
+func removeFiles(paths ...string) error {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"context"
+	"docker-machine-driver-utm/pkg/utm"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	ShareModeVirtFS = "virtfs"
+	ShareModeWebDAV = "webdav"
+
+	// shareTag is the mount tag UTM gives the shared directory of both
+	// backends.
+	shareTag = "share"
+	// webDAVURL is where spice-webdavd serves the shared directory in the
+	// guest.
+	webDAVURL = "http://127.0.0.1:9843"
+	// shareMountTimeout bounds the mount command run in the guest.
+	shareMountTimeout = 30 * time.Second
+)
+
+// parseShareFolder splits a share in host:guest form. Both paths must be
+// absolute, and the host path must be a directory.
+func parseShareFolder(s string) (host, guest string, err error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid share folder %q: expected host:guest", s)
+	}
+	host, guest = s[:i], s[i+1:]
+	if !filepath.IsAbs(host) || !path.IsAbs(guest) {
+		return "", "", fmt.Errorf("invalid share folder %q: both paths must be absolute", s)
+	}
+	info, err := os.Stat(host)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid share folder %q: %w", s, err)
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("invalid share folder %q: %s is not a directory", s, host)
+	}
+	return host, guest, nil
+}
+
+// sharePaths returns the host and guest paths of the share.
+func (d *Driver) sharePaths() (host, guest string) {
+	i := strings.LastIndex(d.ShareFolder, ":")
+	return d.ShareFolder[:i], d.ShareFolder[i+1:]
+}
+
+// shareMode returns the UTM directory share mode of a QEMU VM.
+func (d *Driver) shareMode() utm.DirectoryShareMode {
+	if d.ShareFolder == "" {
+		return ""
+	}
+	if d.ShareMode == ShareModeWebDAV {
+		return utm.DirectoryShareModeWebDAV
+	}
+	return utm.DirectoryShareModeVirtFS
+}
+
+// mountShareScript returns the shell script that mounts the share at its
+// guest path, unless it is mounted already.
+func (d *Driver) mountShareScript() string {
+	_, guest := d.sharePaths()
+	dir := shellQuote(guest)
+
+	var mount string
+	switch {
+	case d.VM.Backend == utm.VmBackendApple:
+		mount = "mount -t virtiofs " + shareTag + " " + dir
+	case d.ShareMode == ShareModeWebDAV:
+		mount = "mount -t davfs " + webDAVURL + " " + dir
+	default:
+		mount = "mount -t 9p -o trans=virtio,version=9p2000.L " + shareTag + " " + dir
+	}
+	return fmt.Sprintf("mountpoint -q %s || { mkdir -p %s && %s; }", dir, dir, mount)
+}
+
+// mountShare mounts the share in the guest through the guest agent, or SSH
+// when the agent is not available. The machine is usable without its share,
+// so failures are only reported. A QEMU VM has nothing to mount until its
+// shared directory is picked in UTM, which scripts cannot do.
+func (d *Driver) mountShare() {
+	if d.ShareFolder == "" {
+		return
+	}
+	log.Infof("Mounting shared folder %s...", d.ShareFolder)
+	script := d.mountShareScript()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shareMountTimeout)
+	defer cancel()
+	res, err := d.utmClient().Exec(ctx, d.VM, "/bin/sh", []string{"-c", script}, nil)
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("exit status %d: %s", res.ExitCode, strings.TrimSpace(string(res.Stderr)))
+	}
+	if err == nil {
+		return
+	}
+	log.Debugf("Guest agent mount failed: %v", err)
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo sh -c "+shellQuote(script)); err != nil {
+		log.Warnf("Could not mount shared folder %s: %v", d.ShareFolder, err)
+		if d.VM.Backend != utm.VmBackendApple {
+			host, _ := d.sharePaths()
+			log.Warnf("Select %s as the shared directory of %s in UTM, then restart the machine", host, d.VM.Name)
+		}
+	}
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseShareFolder(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	host, guest, err := parseShareFolder(dir + ":/Users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != dir || guest != "/Users" {
+		t.Fatalf("unexpected paths %s and %s", host, guest)
+	}
+	for _, bad := range []string{
+		dir,
+		dir + ":Users",
+		"src:/src",
+		file + ":/src",
+		filepath.Join(dir, "missing") + ":/src",
+	} {
+		if _, _, err := parseShareFolder(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("/Users/o'neil/src"); got != `'/Users/o'\''neil/src'` {
+		t.Fatalf("unexpected quoting %s", got)
+	}
+}
+
+func TestSetConfigFromFlagsShare(t *testing.T) {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	flags := &fakeFlags{Data: map[string]interface{}{
+		"utm-backend":      "qemu",
+		"utm-network":      "shared",
+		"utm-share-folder": t.TempDir() + ":/src",
+	}}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	if d.ShareMode != ShareModeVirtFS {
+		t.Fatalf("expected the virtfs share mode, got %s", d.ShareMode)
+	}
+
+	flags.Data["utm-share-mode"] = "smb"
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected an unknown share mode to be rejected")
+	}
+	flags.Data["utm-backend"] = "apple"
+	flags.Data["utm-share-mode"] = ShareModeWebDAV
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected webdav to be rejected on the apple backend")
+	}
+}
+
+func TestDriverShareFolder(t *testing.T) {
+	for _, tc := range []struct {
+		backend utm.VmBackend
+		mode    string
+		want    string
+	}{
+		{utm.VmBackendQemu, ShareModeVirtFS, "mountpoint -q '/src' || { mkdir -p '/src' && mount -t 9p -o trans=virtio,version=9p2000.L share '/src'; }"},
+		{utm.VmBackendQemu, ShareModeWebDAV, "mountpoint -q '/src' || { mkdir -p '/src' && mount -t davfs http://127.0.0.1:9843 '/src'; }"},
+		{utm.VmBackendApple, ShareModeVirtFS, "mountpoint -q '/src' || { mkdir -p '/src' && mount -t virtiofs share '/src'; }"},
+	} {
+		t.Run(string(tc.backend)+"/"+tc.mode, func(t *testing.T) {
+			var commands []utmtest.Command
+			backend := utmtest.New()
+			backend.Exec = func(vm string, cmd utmtest.Command) utm.ExecResult {
+				commands = append(commands, cmd)
+				return utm.ExecResult{}
+			}
+			host := t.TempDir()
+			d := newTestDriver(t, backend)
+			d.Boot2DockerURL = writeTestISO(t)
+			d.Backend = string(tc.backend)
+			d.ShareFolder = host + ":/src"
+			d.ShareMode = tc.mode
+			if err := d.Create(); err != nil {
+				t.Fatal(err)
+			}
+
+			vm, _ := backend.VM("docker-machine-test")
+			if tc.backend == utm.VmBackendApple {
+				if len(vm.AppleConfig.DirectoryShares) != 1 || string(vm.AppleConfig.DirectoryShares[0].Source) != host {
+					t.Fatalf("unexpected shares %+v", vm.AppleConfig.DirectoryShares)
+				}
+			} else if vm.Config.DirectoryShare != d.shareMode() {
+				t.Fatalf("unexpected share mode %s", vm.Config.DirectoryShare)
+			}
+
+			if len(commands) != 1 || commands[0].Path != "/bin/sh" || !slices.Equal(commands[0].Args, []string{"-c", tc.want}) {
+				t.Fatalf("unexpected guest commands %+v", commands)
+			}
+		})
+	}
+}
@@ -3,12 +3,16 @@ package driver
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"docker-machine-driver-utm/pkg/utm"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"net"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
@@ -24,20 +28,41 @@ const (
 	IsoFilename    = "boot2docker.iso"
 	B2dURL         = "https://github.com/iIIusi0n/docker-machine-driver-utm/releases/download/v1.0.0/boot2docker.iso"
 	DefaultSSHUser = "docker"
+
+	DefaultStartTimeout = 600
+	DefaultStopTimeout  = 60
 )
 
 type Driver struct {
 	*drivers.BaseDriver
 
-	Memory         int
-	Disk           int
-	CPU            int
-	Network        string
-	HostInterface  string
-	Boot2DockerURL string
-	ISO            string
-	DiskPath       string
-	VM             *utm.VM
+	Memory            int
+	Disk              int
+	CPU               int
+	Network           string
+	HostInterface     string
+	Backend           string
+	Boot2DockerURL    string
+	Boot2DockerSHA256 string
+	CABundle          string
+	StartTimeout      int
+	StopTimeout       int
+	SuspendOnStop     bool
+	KeepOnFailure     bool
+	IPCIDR            string
+	MACAddress        string
+	PortForwards      []string
+	NetworkInterfaces []NetworkInterface
+	ShareFolder       string
+	ShareMode         string
+	SSHHostPort       int
+	DockerHostPort    int
+	ISO               string
+	DiskPath          string
+	VM                *utm.VM
+
+	client *utm.Client
+	mac    net.HardwareAddr
 }
 
 func NewDriver(hostName, storePath string) drivers.Driver {
@@ -50,8 +75,24 @@ func NewDriver(hostName, storePath string) drivers.Driver {
 	}
 }
 
-func (d *Driver) Create() error {
+func (d *Driver) Create() (err error) {
+	rb := &rollback{}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if d.KeepOnFailure {
+			log.Warnf("Create failed, keeping created resources for debugging")
+			return
+		}
+		log.Infof("Create failed, cleaning up...")
+		rb.run()
+	}()
+
 	log.Infof("Creating SSH key...")
+	rb.add("SSH key", func() error {
+		return removeFiles(d.GetSSHKeyPath(), d.GetSSHKeyPath()+".pub")
+	})
 	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
@@ -61,15 +102,73 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	if err := downloadBoot2DockerISO(d.ResolveStorePath(IsoFilename)); err != nil {
+	rb.add("boot2docker ISO", func() error {
+		return removeFiles(d.ResolveStorePath(IsoFilename))
+	})
+	if err := d.prepareISO(); err != nil {
 		return err
 	}
 
 	log.Infof("Generating disk image...")
+	rb.add("disk image", func() error {
+		return removeFiles(d.DiskPath)
+	})
 	if err := d.generateDiskImage(d.Disk); err != nil {
 		return err
 	}
 
+	// UTM may have made the VM even when the script creating it fails, so
+	// the rollback looks it up by name.
+	rb.add("UTM VM", func() error {
+		vm, err := d.lookupVM()
+		if err != nil || vm == nil {
+			return err
+		}
+		d.VM = vm
+		return d.destroyVM()
+	})
+	var vm *utm.VM
+	if d.Backend == string(utm.VmBackendApple) {
+		vm, err = d.createAppleVM()
+	} else {
+		vm, err = d.createQemuVM()
+	}
+	if err != nil {
+		return err
+	}
+	d.VM = vm
+
+	return d.Start()
+}
+
+// destroyVM forces the VM off and deletes it from UTM. A VM that UTM no
+// longer knows is treated as already deleted.
+func (d *Driver) destroyVM() error {
+	sta, err := d.utmClient().GetStatus(d.VM)
+	if errors.Is(err, utm.ErrVMNotFound) {
+		d.VM = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if sta != utm.VmStatusStopped {
+		if err := d.forceShutdown(d.stopTimeout()); err != nil {
+			return err
+		}
+	}
+	if err := d.utmClient().DeleteVmByID(d.VM.ID); err != nil && !errors.Is(err, utm.ErrVMNotFound) {
+		return err
+	}
+	d.VM = nil
+	return nil
+}
+
+func (d *Driver) createQemuVM() (*utm.VM, error) {
+	networks, err := d.qemuNetworks()
+	if err != nil {
+		return nil, err
+	}
 	conf := &utm.QemuConf{
 		Name:         fmt.Sprintf("docker-machine-%s", d.MachineName),
 		Architecture: "x86_64",
@@ -87,23 +186,41 @@ func (d *Driver) Create() error {
 				Source:    utm.QemuDriveSource(d.ResolveStorePath(fmt.Sprintf("%s.img", d.MachineName))),
 			},
 		},
-		Networks: []utm.QemuNetworkConf{
+		Networks:       networks,
+		DirectoryShare: d.shareMode(),
+	}
+	if d.ShareFolder != "" {
+		host, _ := d.sharePaths()
+		log.Warnf("UTM does not let the driver pick the shared directory of a QEMU VM: select %s as the shared directory of %s in UTM", host, conf.Name)
+	}
+
+	return d.utmClient().CreateQemuVM(conf)
+}
+
+func (d *Driver) createAppleVM() (*utm.VM, error) {
+	conf := &utm.AppleConf{
+		Name:   fmt.Sprintf("docker-machine-%s", d.MachineName),
+		Memory: d.Memory,
+		CPU:    d.CPU,
+		Drives: []utm.AppleDriveConf{
+			{
+				Removable: true,
+				Source:    utm.AppleDriveSource(d.ResolveStorePath(IsoFilename)),
+			},
 			{
-				Mode: utm.QemuNetworkMode(d.Network),
+				Source: utm.AppleDriveSource(d.ResolveStorePath(fmt.Sprintf("%s.img", d.MachineName))),
 			},
 		},
+		Networks: d.appleNetworks(),
 	}
-	if conf.Networks[0].Mode == utm.QemuNetworkModeBridged {
-		conf.Networks[0].HostInterface = d.HostInterface
-	}
-
-	vm, err := utm.CreateQemuVM(conf)
-	if err != nil {
-		return err
+	if d.ShareFolder != "" {
+		host, _ := d.sharePaths()
+		conf.DirectoryShares = []utm.AppleDirectoryShareConf{
+			{Source: utm.AppleDirectoryShareSource(host)},
+		}
 	}
-	d.VM = vm
 
-	return d.Start()
+	return d.utmClient().CreateAppleVM(conf)
 }
 
 func (d *Driver) DriverName() string {
@@ -127,9 +244,14 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage: "Number of CPUs for the UTM VM",
 			Value: 1,
 		},
+		mcnflag.StringFlag{
+			Name:  "utm-backend",
+			Usage: "Virtualization backend for the UTM VM (qemu, apple)",
+			Value: string(utm.VmBackendQemu),
+		},
 		mcnflag.StringFlag{
 			Name:  "utm-network",
-			Usage: "Network type for the UTM VM (emulated, shared, host, bridged)",
+			Usage: "Network type for the UTM VM (emulated, shared, host, bridged; apple backend: shared, bridged)",
 			Value: "shared",
 		},
 		mcnflag.StringFlag{
@@ -137,11 +259,67 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage: "Host interface for the UTM VM (bridged mode)",
 			Value: "",
 		},
+		mcnflag.StringSliceFlag{
+			Name:  "utm-network-interface",
+			Usage: "Add a network interface, as mode[:hostIface][,mac=..][,hardware=..][,primary]; repeatable",
+		},
+		mcnflag.StringFlag{
+			Name:  "utm-ip-cidr",
+			Usage: "Only use a guest IP address within this CIDR, e.g. 192.168.64.0/24",
+			Value: "",
+		},
+		mcnflag.StringFlag{
+			Name:  "utm-mac-address",
+			Usage: "MAC address of the UTM VM's network interface (default: derived from the machine name)",
+			Value: "",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "utm-port-forward",
+			Usage: "Forward a localhost port to the guest (emulated network), as host:guest[/tcp|udp]; repeatable",
+		},
+		mcnflag.StringFlag{
+			Name:  "utm-share-folder",
+			Usage: "Share a host directory with the guest, as host:guest, e.g. /Users:/Users",
+			Value: "",
+		},
+		mcnflag.StringFlag{
+			Name:  "utm-share-mode",
+			Usage: "Directory sharing of the qemu backend (virtfs, webdav); the apple backend uses virtiofs",
+			Value: ShareModeVirtFS,
+		},
 		mcnflag.StringFlag{
 			Name:  "utm-boot2docker-url",
-			Usage: "URL to the boot2docker ISO",
+			Usage: "URL or local path to the boot2docker ISO (http, https, file)",
+			Value: "",
+		},
+		mcnflag.StringFlag{
+			Name:  "utm-boot2docker-sha256",
+			Usage: "Expected SHA-256 checksum of the boot2docker ISO",
 			Value: "",
 		},
+		mcnflag.StringFlag{
+			Name:  "utm-ca-bundle",
+			Usage: "PEM file with additional CA certificates trusted when downloading the ISO",
+			Value: "",
+		},
+		mcnflag.IntFlag{
+			Name:  "utm-start-timeout",
+			Usage: "Seconds to wait for the UTM VM to boot and report an IP address",
+			Value: DefaultStartTimeout,
+		},
+		mcnflag.IntFlag{
+			Name:  "utm-stop-timeout",
+			Usage: "Seconds to wait for the guest to shut down before forcing it off",
+			Value: DefaultStopTimeout,
+		},
+		mcnflag.BoolFlag{
+			Name:  "utm-suspend-on-stop",
+			Usage: "Suspend the UTM VM on stop instead of shutting it down",
+		},
+		mcnflag.BoolFlag{
+			Name:  "utm-keep-on-failure",
+			Usage: "Keep the UTM VM and generated files when create fails, for debugging",
+		},
 		mcnflag.StringFlag{
 			Name:  "utm-ssh-user",
 			Usage: "SSH user for the UTM VM",
@@ -155,22 +333,63 @@ func (d *Driver) GetIP() (string, error) {
 		return "", err
 	}
 
-	sta, err := d.VM.GetStatus()
+	sta, err := d.utmClient().GetStatus(d.VM)
 	if err != nil {
 		return "", err
 	}
 
-	if sta == utm.VmStatusStarted {
-		return d.VM.GetIP()
+	if sta != utm.VmStatusStarted {
+		return "", nil
+	}
+	if d.forwarded() {
+		// The guest is only reachable through the forwards, which need no
+		// guest address, nor a guest agent to report one.
+		return forwardHost, nil
+	}
+
+	ips, err := d.utmClient().GetIPs(d.VM)
+	if err != nil && !errors.Is(err, utm.ErrGuestAgentUnavailable) {
+		return "", err
 	}
+	return d.chooseIP(ips), nil
+}
 
-	return "", nil
+// chooseIP picks the guest address the host should use, see selectIP. When
+// the guest agent reports none, it falls back to the DHCP leases.
+//
+// The guest agent does not tell which interface an address belongs to, so
+// with several interfaces only the lease of the primary one is used, and no
+// address is returned until the guest reports it. Only the first interface,
+// which the guest agent lists first, may have no lease at all, as on a
+// bridged network.
+func (d *Driver) chooseIP(ips []string) string {
+	cidr, _ := netip.ParsePrefix(d.IPCIDR)
+	if len(d.NetworkInterfaces) == 0 {
+		if ip := selectIP(ips, cidr); ip != "" {
+			return ip
+		}
+		return selectIP([]string{d.leaseIP()}, cidr)
+	}
+	lease := selectIP([]string{d.leaseIP()}, cidr)
+	if lease != "" {
+		if ips == nil || slices.Contains(ips, lease) {
+			return lease
+		}
+		return ""
+	}
+	if d.primaryInterface() == 0 {
+		return selectIP(ips, cidr)
+	}
+	return ""
 }
 
 func (d *Driver) GetMachineName() string {
 	return d.MachineName
 }
 
+// GetSSHHostname returns the bare guest address, even for IPv6: libmachine
+// adds the brackets itself when it joins the host and port. On the emulated
+// network it is localhost, see GetSSHPort.
 func (d *Driver) GetSSHHostname() (string, error) {
 	return d.GetIP()
 }
@@ -179,7 +398,12 @@ func (d *Driver) GetSSHKeyPath() string {
 	return d.ResolveStorePath("id_rsa")
 }
 
+// GetSSHPort returns the port forwarded to sshd on the emulated network, and
+// the guest port otherwise.
 func (d *Driver) GetSSHPort() (int, error) {
+	if d.forwarded() {
+		return d.SSHHostPort, nil
+	}
 	if d.SSHPort == 0 {
 		d.SSHPort = 22
 	}
@@ -203,21 +427,38 @@ func (d *Driver) GetURL() (string, error) {
 		return "", nil
 	}
 
-	return fmt.Sprintf("tcp://%s:2376", ip), nil
+	port := dockerPort
+	if d.forwarded() {
+		port = d.DockerHostPort
+	}
+	return "tcp://" + net.JoinHostPort(ip, strconv.Itoa(port)), nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
 	if err := d.validateVM(); err != nil {
+		if errors.Is(err, utm.ErrVMNotFound) {
+			return state.Error, err
+		}
 		return state.None, err
 	}
 
-	sta, err := d.VM.GetStatus()
-	if err != nil {
+	sta, err := d.utmClient().GetStatus(d.VM)
+	switch {
+	case errors.Is(err, utm.ErrUTMNotRunning):
+		// No VM can run without UTM.
+		return state.Stopped, nil
+	case errors.Is(err, utm.ErrVMNotFound):
+		return state.Error, err
+	case err != nil:
 		return state.None, err
 	}
 
 	ip, err := d.GetIP()
-	if err != nil {
+	if errors.Is(err, utm.ErrGuestAgentUnavailable) || errors.Is(err, utm.ErrInvalidState) {
+		// The guest agent comes up late in the boot, and the VM may
+		// have changed state since its status was read.
+		ip = ""
+	} else if err != nil {
 		return state.None, err
 	}
 
@@ -244,36 +485,60 @@ func (d *Driver) Kill() error {
 	if err := d.validateVM(); err != nil {
 		return err
 	}
-	return d.VM.Kill()
-}
-
-func (d *Driver) PreCreateCheck() error {
-	return nil
+	return d.utmClient().Kill(d.VM)
 }
 
 func (d *Driver) Remove() error {
-	err := d.VM.Stop()
+	vm, err := d.lookupVM()
 	if err != nil {
-		log.Infof("Error while stopping VM: %v", err)
+		return fmt.Errorf("looking up UTM VM: %w", err)
 	}
 
-	time.Sleep(1 * time.Second)
-
-	log.Infof("Removing UTM VM...")
-	err = d.validateVM()
-	if err != nil {
-		log.Infof("Error while validating VM: %v", err)
+	var errs []error
+	if vm == nil {
+		log.Infof("UTM VM not found, assuming it was already removed")
+	} else {
+		log.Infof("Removing UTM VM...")
+		d.VM = vm
+		if err := d.destroyVM(); err != nil {
+			errs = append(errs, fmt.Errorf("removing UTM VM: %w", err))
+		}
 	}
 
-	err = utm.DeleteVmByID(d.VM.ID)
-	if err != nil {
-		log.Infof("Error while deleting VM: %v", err)
+	if err := removeFiles(d.DiskPath, d.ResolveStorePath(IsoFilename), d.GetSSHKeyPath(), d.GetSSHKeyPath()+".pub"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
 	}
 
 	log.Infof("VM removed successfully")
 	return nil
 }
 
+// lookupVM resolves the machine's VM by its stored ID, falling back to its
+// name. It returns nil when UTM does not know the VM.
+func (d *Driver) lookupVM() (*utm.VM, error) {
+	vms, err := d.utmClient().ListVMs()
+	if err != nil {
+		return nil, err
+	}
+	if d.VM != nil && d.VM.ID != "" {
+		for _, vm := range vms {
+			if vm.ID == d.VM.ID {
+				return vm, nil
+			}
+		}
+	}
+	name := fmt.Sprintf("docker-machine-%s", d.MachineName)
+	for _, vm := range vms {
+		if vm.Name == name {
+			return vm, nil
+		}
+	}
+	return nil, nil
+}
+
 func (d *Driver) Restart() error {
 	if err := d.validateVM(); err != nil {
 		return err
@@ -293,7 +558,73 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.CPU = flags.Int("utm-cpu")
 	d.Network = flags.String("utm-network")
 	d.HostInterface = flags.String("utm-host-interface")
+	d.Backend = flags.String("utm-backend")
 	d.Boot2DockerURL = flags.String("utm-boot2docker-url")
+	sum, err := normalizeSHA256(flags.String("utm-boot2docker-sha256"))
+	if err != nil {
+		return err
+	}
+	d.Boot2DockerSHA256 = sum
+	d.CABundle = flags.String("utm-ca-bundle")
+	d.StartTimeout = flags.Int("utm-start-timeout")
+	d.StopTimeout = flags.Int("utm-stop-timeout")
+	d.SuspendOnStop = flags.Bool("utm-suspend-on-stop")
+	d.KeepOnFailure = flags.Bool("utm-keep-on-failure")
+	d.IPCIDR = flags.String("utm-ip-cidr")
+	if d.IPCIDR != "" {
+		if _, err := netip.ParsePrefix(d.IPCIDR); err != nil {
+			return fmt.Errorf("invalid --utm-ip-cidr: %w", err)
+		}
+	}
+	mac := machineMAC(d.MachineName)
+	if addr := flags.String("utm-mac-address"); addr != "" {
+		if mac, err = parseMAC(addr); err != nil {
+			return fmt.Errorf("invalid --utm-mac-address: %w", err)
+		}
+		if mac[0]&0x01 != 0 {
+			return fmt.Errorf("invalid --utm-mac-address: %s is a multicast address", addr)
+		}
+	}
+	d.MACAddress = mac.String()
+	d.NetworkInterfaces = nil
+	primary := false
+	for i, s := range flags.StringSlice("utm-network-interface") {
+		nic, err := parseNetworkInterface(s)
+		if err != nil {
+			return err
+		}
+		if nic.Primary && primary {
+			return fmt.Errorf("only one --utm-network-interface can be primary")
+		}
+		primary = primary || nic.Primary
+		if nic.MAC == "" {
+			nic.MAC = machineMAC(fmt.Sprintf("%s/%d", d.MachineName, i+1)).String()
+		}
+		d.NetworkInterfaces = append(d.NetworkInterfaces, nic)
+	}
+	d.PortForwards = flags.StringSlice("utm-port-forward")
+	for _, s := range d.PortForwards {
+		if _, err := parsePortForward(s); err != nil {
+			return err
+		}
+	}
+	if len(d.PortForwards) > 0 && d.Network != string(utm.QemuNetworkModeEmulated) {
+		return fmt.Errorf("--utm-port-forward requires the emulated network")
+	}
+	d.ShareFolder = flags.String("utm-share-folder")
+	if d.ShareFolder != "" {
+		if _, _, err := parseShareFolder(d.ShareFolder); err != nil {
+			return err
+		}
+	}
+	d.ShareMode = flags.String("utm-share-mode")
+	switch d.ShareMode {
+	case "":
+		d.ShareMode = ShareModeVirtFS
+	case ShareModeVirtFS, ShareModeWebDAV:
+	default:
+		return fmt.Errorf("unsupported share mode: %s", d.ShareMode)
+	}
 
 	d.SwarmMaster = flags.Bool("swarm-master")
 	d.SwarmHost = flags.String("swarm-host")
@@ -302,6 +633,32 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.SSHUser = flags.String("utm-ssh-user")
 	d.SSHPort = 22
 	d.DiskPath = d.ResolveStorePath(fmt.Sprintf("%s.img", d.MachineName))
+
+	switch utm.VmBackend(d.Backend) {
+	case utm.VmBackendQemu:
+	case utm.VmBackendApple:
+		if d.Boot2DockerURL == "" && hostArch != "amd64" {
+			return fmt.Errorf("the default boot2docker ISO is built for amd64 and the apple backend cannot run it on %s: pass an %s ISO with --utm-boot2docker-url", hostArch, hostArch)
+		}
+		mode := utm.AppleNetworkMode(d.Network)
+		if mode != utm.AppleNetworkModeShared && mode != utm.AppleNetworkModeBridged {
+			return fmt.Errorf("network type %q is not supported by the apple backend", d.Network)
+		}
+		for _, nic := range d.NetworkInterfaces {
+			mode := utm.AppleNetworkMode(nic.Mode)
+			if mode != utm.AppleNetworkModeShared && mode != utm.AppleNetworkModeBridged {
+				return fmt.Errorf("network type %q is not supported by the apple backend", nic.Mode)
+			}
+			if nic.Hardware != "" {
+				return fmt.Errorf("the apple backend does not support choosing the network hardware")
+			}
+		}
+		if d.ShareMode == ShareModeWebDAV {
+			return fmt.Errorf("the apple backend shares directories with virtiofs, not webdav")
+		}
+	default:
+		return fmt.Errorf("unsupported backend: %s", d.Backend)
+	}
 	return nil
 }
 
@@ -311,22 +668,44 @@ func (d *Driver) Start() error {
 		return err
 	}
 
-	err := d.VM.Start()
+	sta, err := d.utmClient().GetStatus(d.VM)
 	if err != nil {
 		return err
 	}
+	switch sta {
+	case utm.VmStatusStopped:
+		if err := d.applyConfiguration(); err != nil {
+			return err
+		}
+		fallthrough
+	case utm.VmStatusPaused:
+		if err := d.utmClient().Start(d.VM); err != nil {
+			return err
+		}
+	case utm.VmStatusStarted, utm.VmStatusStarting, utm.VmStatusResuming:
+		log.Infof("VM is already %s", sta)
+	default:
+		return fmt.Errorf("cannot start VM while it is %s: %w", sta, utm.ErrInvalidState)
+	}
 
-	log.Infof("Waiting for VM to get an IP address...")
-	for i := 0; i < 120; i++ {
-		ip, err := d.GetIP()
-		if err == nil && ip != "" {
-			log.Infof("VM started successfully with IP: %s", ip)
-			return nil
+	ctx, cancel := context.WithTimeout(context.Background(), d.startTimeout())
+	defer cancel()
+	ip := forwardHost
+	if d.forwarded() {
+		if err := d.utmClient().WaitForStatus(ctx, d.VM, utm.VmStatusStarted); err != nil {
+			return err
+		}
+	} else {
+		log.Infof("Waiting for VM to get an IP address...")
+		ip, err = d.utmClient().WaitForIPFunc(ctx, d.VM, d.chooseIP)
+		if err != nil {
+			return err
 		}
-		time.Sleep(5 * time.Second)
 	}
 
-	return errors.New("timeout waiting for VM to get an IP address")
+	d.mountShare()
+	log.Infof("VM started successfully with IP: %s", ip)
+	return nil
 }
 
 func (d *Driver) Stop() error {
@@ -334,18 +713,119 @@ func (d *Driver) Stop() error {
 	if err := d.validateVM(); err != nil {
 		return err
 	}
-	err := d.VM.Pause()
-	if err != nil {
+
+	if d.SuspendOnStop {
+		err := d.utmClient().Pause(d.VM)
+		if err != nil {
+			return err
+		}
+		log.Infof("VM suspended successfully")
+		return nil
+	}
+
+	if err := d.shutdown(); err != nil {
 		return err
 	}
 	log.Infof("VM stopped successfully")
 	return nil
 }
 
+// shutdown asks the guest to power off, first through an ACPI request and
+// then by running poweroff through the guest agent or SSH. A VM that is
+// already stopping is left to finish. The VM is only forced off when it is
+// still running once the stop timeout expires.
+func (d *Driver) shutdown() error {
+	sta, err := d.utmClient().GetStatus(d.VM)
+	if err != nil {
+		return err
+	}
+	timeout := d.stopTimeout()
+
+	switch sta {
+	case utm.VmStatusStopped:
+		return nil
+	case utm.VmStatusStarted, utm.VmStatusStarting, utm.VmStatusResuming, utm.VmStatusStopping:
+	default:
+		// A paused guest cannot act on a stop request.
+		return d.forceShutdown(timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	switch sta {
+	case utm.VmStatusStopping:
+		log.Infof("Waiting for the VM to stop...")
+		err = d.utmClient().WaitForStatus(ctx, d.VM, utm.VmStatusStopped)
+	case utm.VmStatusStarted:
+		err = d.stopGuest(ctx, timeout)
+	default:
+		// The guest cannot act on a stop request before it is up.
+		err = d.utmClient().WaitForStatus(ctx, d.VM, utm.VmStatusStarted)
+		if err == nil {
+			err = d.stopGuest(ctx, timeout)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	log.Warnf("VM did not shut down within %s, forcing it off: %v", timeout, err)
+	return d.forceShutdown(timeout)
+}
+
+// stopGuest asks a running guest to power off, first through ACPI, then
+// through the guest agent or SSH, and waits for the VM to stop.
+func (d *Driver) stopGuest(ctx context.Context, timeout time.Duration) error {
+	if err := d.utmClient().RequestStop(d.VM); err != nil {
+		log.Debugf("Shutdown request failed: %v", err)
+	} else {
+		acpiCtx, acpiCancel := context.WithTimeout(ctx, timeout/3)
+		err := d.utmClient().WaitForStatus(acpiCtx, d.VM, utm.VmStatusStopped)
+		acpiCancel()
+		if err == nil {
+			return nil
+		}
+	}
+
+	log.Infof("Running poweroff in the guest...")
+	if err := d.utmClient().RunCommandOnVM(d.VM, "/sbin/poweroff"); err != nil {
+		log.Debugf("Guest agent poweroff failed: %v", err)
+		if _, err := drivers.RunSSHCommandFromDriver(d, "sudo poweroff"); err != nil {
+			log.Debugf("SSH poweroff failed: %v", err)
+		}
+	}
+	return d.utmClient().WaitForStatus(ctx, d.VM, utm.VmStatusStopped)
+}
+
+// forceShutdown powers the VM off and waits until it is stopped, so that it
+// can be started again right away.
+func (d *Driver) forceShutdown(timeout time.Duration) error {
+	if err := d.utmClient().Shutdown(d.VM); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.utmClient().WaitForStatus(ctx, d.VM, utm.VmStatusStopped)
+}
+
+func (d *Driver) startTimeout() time.Duration {
+	if d.StartTimeout <= 0 {
+		return DefaultStartTimeout * time.Second
+	}
+	return time.Duration(d.StartTimeout) * time.Second
+}
+
+func (d *Driver) stopTimeout() time.Duration {
+	if d.StopTimeout <= 0 {
+		return DefaultStopTimeout * time.Second
+	}
+	return time.Duration(d.StopTimeout) * time.Second
+}
+
 func (d *Driver) validateVM() error {
 	if d.VM == nil {
 		vmName := fmt.Sprintf("docker-machine-%s", d.MachineName)
-		vm, err := utm.GetVmByName(vmName)
+		vm, err := d.utmClient().GetVmByName(vmName)
 		if err != nil {
 			return err
 		}
@@ -355,6 +835,93 @@ func (d *Driver) validateVM() error {
 	return nil
 }
 
+// applyConfiguration pushes memory, CPU and network settings from the driver
+// config onto a stopped VM, so editing them and restarting resizes the machine.
+func (d *Driver) applyConfiguration() error {
+	if d.VM.Backend == utm.VmBackendApple {
+		return d.applyAppleConfiguration()
+	}
+
+	conf, err := d.utmClient().GetConfiguration(d.VM)
+	if err != nil {
+		return err
+	}
+
+	update := &utm.QemuConf{}
+	changed := false
+	if d.Memory > 0 && conf.Memory != d.Memory {
+		update.Memory = d.Memory
+		changed = true
+	}
+	if d.CPU > 0 && conf.CPU != d.CPU {
+		update.CPU = d.CPU
+		changed = true
+	}
+	if mode := d.shareMode(); mode != "" && conf.DirectoryShare != mode {
+		update.DirectoryShare = mode
+		changed = true
+	}
+	if d.Network != "" && len(conf.Networks) > 0 {
+		networks, err := d.qemuNetworks()
+		if err != nil {
+			return err
+		}
+		for _, want := range networks {
+			i := slices.IndexFunc(conf.Networks, func(n utm.QemuNetworkConf) bool { return n.Index == want.Index })
+			if i < 0 || qemuNetworkChanged(conf.Networks[i], want) {
+				update.Networks = append(update.Networks, want)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	log.Infof("Updating UTM VM configuration...")
+	return d.utmClient().UpdateQemuVM(d.VM, update)
+}
+
+func (d *Driver) applyAppleConfiguration() error {
+	conf, err := d.utmClient().GetAppleConfiguration(d.VM)
+	if err != nil {
+		return err
+	}
+
+	update := &utm.AppleConf{}
+	changed := false
+	if d.Memory > 0 && conf.Memory != d.Memory {
+		update.Memory = d.Memory
+		changed = true
+	}
+	if d.CPU > 0 && conf.CPU != d.CPU {
+		update.CPU = d.CPU
+		changed = true
+	}
+	if d.Network != "" && len(conf.Networks) > 0 {
+		for _, want := range d.appleNetworks() {
+			i := slices.IndexFunc(conf.Networks, func(n utm.AppleNetworkConf) bool { return n.Index == want.Index })
+			if i < 0 || appleNetworkChanged(conf.Networks[i], want) {
+				update.Networks = append(update.Networks, want)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	log.Infof("Updating UTM VM configuration...")
+	return d.utmClient().UpdateAppleVM(d.VM, update)
+}
+
+func (d *Driver) utmClient() *utm.Client {
+	if d.client == nil {
+		return utm.DefaultClient
+	}
+	return d.client
+}
+
 func (d *Driver) generateDiskImage(size int) error {
 	log.Debugf("Creating %d MB hard disk image...", size)
 
@@ -415,18 +982,3 @@ func createDiskImage(dest string, size int, r io.Reader) error {
 	f.Write([]byte{0})
 	return f.Close()
 }
-
-func downloadBoot2DockerISO(path string) error {
-	resp, err := http.Get(B2dURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(path, data, 0644)
-}
@@ -0,0 +1,548 @@
+package driver
+
+import (
+	"docker-machine-driver-utm/pkg/utm"
+	"docker-machine-driver-utm/pkg/utm/utmtest"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/state"
+)
+
+type fakeFlags struct {
+	Data map[string]interface{}
+}
+
+func (f *fakeFlags) String(key string) string {
+	if v, ok := f.Data[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (f *fakeFlags) StringSlice(key string) []string {
+	if v, ok := f.Data[key].([]string); ok {
+		return v
+	}
+	return nil
+}
+
+func (f *fakeFlags) Int(key string) int {
+	if v, ok := f.Data[key].(int); ok {
+		return v
+	}
+	return 0
+}
+
+func (f *fakeFlags) Bool(key string) bool {
+	if v, ok := f.Data[key].(bool); ok {
+		return v
+	}
+	return false
+}
+
+func newTestDriver(t *testing.T, backend *utmtest.Backend) *Driver {
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	d.Memory = 1024
+	d.Disk = 64
+	d.CPU = 1
+	d.Network = string(utm.QemuNetworkModeShared)
+	d.DiskPath = d.ResolveStorePath("test.img")
+	d.client = backend.Client()
+	if err := os.MkdirAll(d.ResolveStorePath("."), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDriverLifecycle(t *testing.T) {
+	backend := utmtest.New()
+	backend.StopDelay = 100 * time.Millisecond
+	d := newTestDriver(t, backend)
+	d.Boot2DockerURL = writeTestISO(t)
+
+	if err := d.Create(); err != nil {
+		t.Fatal(err)
+	}
+	vm, ok := backend.VM("docker-machine-test")
+	if !ok {
+		t.Fatal("expected vm to be created")
+	}
+	if len(vm.Config.Drives) != 2 || string(vm.Config.Drives[0].Source) != d.ResolveStorePath(IsoFilename) {
+		t.Fatalf("unexpected drives: %+v", vm.Config.Drives)
+	}
+
+	st, err := d.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != state.Running {
+		t.Fatalf("expected %s, got %s", state.Running, st)
+	}
+	url, err := d.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url == "" {
+		t.Fatal("expected a docker url")
+	}
+
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	st, err = d.GetState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != state.Stopped {
+		t.Fatalf("expected %s, got %s", state.Stopped, st)
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := backend.VM("docker-machine-test"); ok {
+		t.Fatal("expected vm to be deleted")
+	}
+}
+
+func TestDriverStartAppliesConfiguration(t *testing.T) {
+	backend := utmtest.New()
+	d := newTestDriver(t, backend)
+
+	vm, err := backend.Client().CreateQemuVM(&utm.QemuConf{
+		Name:   "docker-machine-test",
+		Memory: 1024,
+		CPU:    1,
+		Drives: []utm.QemuDriveConf{
+			{Removable: true, Source: "/tmp/boot2docker.iso"},
+			{Raw: true, Interface: utm.QemuDriveInterfaceIDE, Source: "/tmp/test.img"},
+		},
+		Networks: []utm.QemuNetworkConf{{Mode: utm.QemuNetworkModeShared}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Memory = 4096
+	d.CPU = 4
+	d.Network = string(utm.QemuNetworkModeBridged)
+	d.HostInterface = "en0"
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := backend.Client().GetConfiguration(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Memory != 4096 || conf.CPU != 4 {
+		t.Fatalf("unexpected resources: %+v", conf)
+	}
+	if len(conf.Drives) != 2 {
+		t.Fatalf("expected drives to be kept, got %+v", conf.Drives)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].Mode != utm.QemuNetworkModeBridged || conf.Networks[0].HostInterface != "en0" {
+		t.Fatalf("unexpected networks: %+v", conf.Networks)
+	}
+}
+
+func TestDriverStartBridgedToShared(t *testing.T) {
+	backend := utmtest.New()
+	vm, err := backend.Client().CreateQemuVM(&utm.QemuConf{
+		Name:     "docker-machine-test",
+		Networks: []utm.QemuNetworkConf{{Mode: utm.QemuNetworkModeBridged, HostInterface: "en0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDriver(t, backend)
+	d.Network = string(utm.QemuNetworkModeShared)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := backend.Client().GetConfiguration(vm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].Mode != utm.QemuNetworkModeShared || conf.Networks[0].HostInterface != "" {
+		t.Fatalf("unexpected networks: %+v", conf.Networks)
+	}
+
+	if err := d.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	updates := countUpdates(backend)
+	if updates == 0 {
+		t.Fatal("expected the first start to update the configuration")
+	}
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countUpdates(backend); n != updates {
+		t.Fatalf("expected no configuration update on the second start, got %d", n-updates)
+	}
+}
+
+func countUpdates(backend *utmtest.Backend) int {
+	n := 0
+	for _, script := range backend.Scripts() {
+		for _, line := range script {
+			if strings.HasPrefix(strings.TrimSpace(line), "update configuration of vm") {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestSetConfigFromFlagsBackend(t *testing.T) {
+	arch := hostArch
+	t.Cleanup(func() { hostArch = arch })
+	hostArch = "amd64"
+	d := NewDriver("test", t.TempDir()).(*Driver)
+	flags := &fakeFlags{
+		Data: map[string]interface{}{
+			"utm-backend": "apple",
+			"utm-network": "shared",
+		},
+	}
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+	if d.Backend != "apple" {
+		t.Fatalf("unexpected backend: %s", d.Backend)
+	}
+
+	hostArch = "arm64"
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected the amd64 default ISO to be rejected for the apple backend on arm64")
+	}
+	flags.Data["utm-boot2docker-url"] = "https://example.com/boot2docker-arm64.iso"
+	if err := d.SetConfigFromFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+
+	flags.Data["utm-network"] = "emulated"
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected emulated networking to be rejected for the apple backend")
+	}
+
+	flags.Data["utm-backend"] = "hyperkit"
+	if err := d.SetConfigFromFlags(flags); err == nil {
+		t.Fatal("expected unknown backend to be rejected")
+	}
+}
+
+func TestCreateAppleVM(t *testing.T) {
+	backend := utmtest.New()
+	d := newTestDriver(t, backend)
+	d.Backend = string(utm.VmBackendApple)
+
+	vm, err := d.createAppleVM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Backend != utm.VmBackendApple {
+		t.Fatalf("unexpected backend: %s", vm.Backend)
+	}
+	conf, err := vm.GetAppleConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Memory != 1024 || len(conf.Drives) != 2 || len(conf.Networks) != 1 {
+		t.Fatalf("unexpected configuration: %+v", conf)
+	}
+
+	d.VM = vm
+	d.Memory = 2048
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conf, err = vm.GetAppleConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Memory != 2048 || len(conf.Drives) != 2 {
+		t.Fatalf("unexpected configuration after start: %+v", conf)
+	}
+}
+
+func closedPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func TestDriverStop(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		acpi       bool
+		guestAgent bool
+		suspend    bool
+		stopping   bool
+		want       utm.VmStatus
+		forced     bool
+	}{
+		{name: "acpi", acpi: true, guestAgent: true, want: utm.VmStatusStopped},
+		{name: "guest agent", guestAgent: true, want: utm.VmStatusStopped},
+		{name: "force", want: utm.VmStatusStopped, forced: true},
+		{name: "suspend", acpi: true, guestAgent: true, suspend: true, want: utm.VmStatusPaused},
+		{name: "stopping", acpi: true, stopping: true, want: utm.VmStatusStopped},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := utmtest.New()
+			backend.StopDelay = 50 * time.Millisecond
+			backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+			backend.SetIPs("docker-machine-test", "127.0.0.1")
+			backend.SetACPI("docker-machine-test", tc.acpi)
+			backend.SetGuestAgent("docker-machine-test", tc.guestAgent)
+
+			d := newTestDriver(t, backend)
+			d.SSHPort = closedPort(t)
+			d.StopTimeout = 1
+			d.SuspendOnStop = tc.suspend
+			if tc.stopping {
+				vm, err := backend.Client().GetVmByName("docker-machine-test")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := vm.RequestStop(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.Stop(); err != nil {
+				t.Fatal(err)
+			}
+
+			vm, _ := backend.VM("docker-machine-test")
+			if vm.Status != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, vm.Status)
+			}
+			forced := false
+			for _, script := range backend.Scripts() {
+				if slices.Contains(script, "stop vm by force") {
+					forced = true
+				}
+			}
+			if forced != tc.forced {
+				t.Fatalf("expected forced=%t, got %t", tc.forced, forced)
+			}
+		})
+	}
+}
+
+func TestDriverRestartForced(t *testing.T) {
+	useLeaseFixtures(t)
+	backend := utmtest.New()
+	backend.StopDelay = 200 * time.Millisecond
+	backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+	backend.SetACPI("docker-machine-test", false)
+	backend.SetGuestAgent("docker-machine-test", false)
+
+	d := newTestDriver(t, backend)
+	d.SSHPort = closedPort(t)
+	d.StopTimeout = 1
+	d.MACAddress = "52:54:00:00:00:01"
+	if err := d.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	vm, _ := backend.VM("docker-machine-test")
+	if vm.Status != utm.VmStatusStarted {
+		t.Fatalf("expected the vm to be started again, got %s", vm.Status)
+	}
+}
+
+func TestDriverStartTimeout(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStopped)
+	backend.SetGuestAgent("docker-machine-test", false)
+
+	d := newTestDriver(t, backend)
+	d.StartTimeout = 1
+	err := d.Start()
+	if err == nil {
+		t.Fatal("expected start to time out without an IP address")
+	}
+	if !strings.Contains(err.Error(), "last status: started") {
+		t.Fatalf("expected last status in error, got %v", err)
+	}
+}
+
+func TestDriverCreateRollback(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		backend := utmtest.New()
+		d := newTestDriver(t, backend)
+		d.Boot2DockerURL = writeTestISO(t)
+		d.KeepOnFailure = keep
+
+		backend.AddVM("unrelated", utm.VmStatusStopped)
+		backend.Fail("start vm")
+		if err := d.Create(); err == nil {
+			t.Fatal("expected create to fail")
+		}
+
+		_, vmExists := backend.VM("docker-machine-test")
+		files := []string{d.GetSSHKeyPath(), d.GetSSHKeyPath() + ".pub", d.ResolveStorePath(IsoFilename), d.DiskPath}
+		for _, path := range files {
+			_, err := os.Stat(path)
+			if keep && err != nil {
+				t.Fatalf("expected %s to be kept: %v", path, err)
+			}
+			if !keep && !os.IsNotExist(err) {
+				t.Fatalf("expected %s to be removed", path)
+			}
+		}
+		if vmExists != keep {
+			t.Fatalf("keep=%t: unexpected vm existence %t", keep, vmExists)
+		}
+		if _, ok := backend.VM("unrelated"); !ok {
+			t.Fatal("expected unrelated vm to be left alone")
+		}
+	}
+}
+
+func TestDriverCreateRollbackAfterMake(t *testing.T) {
+	for _, vmBackend := range []utm.VmBackend{utm.VmBackendQemu, utm.VmBackendApple} {
+		t.Run(string(vmBackend), func(t *testing.T) {
+			backend := utmtest.New()
+			d := newTestDriver(t, backend)
+			d.Boot2DockerURL = writeTestISO(t)
+			d.Backend = string(vmBackend)
+
+			// UTM makes the VM, then the script fails before returning it.
+			backend.Fail("return {id: id of vm, name: name of vm, backend: backend of vm, status: status of vm}")
+			if err := d.Create(); err == nil {
+				t.Fatal("expected create to fail")
+			}
+			if vms := backend.VMs(); len(vms) != 0 {
+				t.Fatalf("expected no vm to be left behind, got %+v", vms)
+			}
+		})
+	}
+}
+
+func TestDriverRemove(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+	d := newTestDriver(t, backend)
+	for _, path := range []string{d.DiskPath, d.ResolveStorePath(IsoFilename)} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := backend.VM("docker-machine-test"); ok {
+		t.Fatal("expected vm to be deleted")
+	}
+	for _, path := range []string{d.DiskPath, d.ResolveStorePath(IsoFilename)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", path)
+		}
+	}
+
+	// The VM is already gone, so a second Remove succeeds.
+	if err := d.Remove(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriverRemoveErrors(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStopped)
+	d := newTestDriver(t, backend)
+
+	backend.Fail(`delete virtual machine id "00000000-0000-0000-0000-000000000001"`)
+	if err := d.Remove(); err == nil {
+		t.Fatal("expected failed delete to be reported")
+	}
+
+	backend.Fail("set output to {}")
+	if err := d.Remove(); err == nil {
+		t.Fatal("expected failed lookup to be reported")
+	}
+}
+
+func TestDriverGetStateErrors(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+	backend.SetGuestAgent("docker-machine-test", false)
+	d := newTestDriver(t, backend)
+
+	st, err := d.GetState()
+	if err != nil || st != state.Starting {
+		t.Fatalf("expected starting without a guest agent, got %s, %v", st, err)
+	}
+
+	backend.Quit()
+	st, err = d.GetState()
+	if err != nil || st != state.Stopped {
+		t.Fatalf("expected stopped when UTM is not running, got %s, %v", st, err)
+	}
+	backend.Launch()
+
+	if err := backend.Client().DeleteVmByName("docker-machine-test"); err != nil {
+		t.Fatal(err)
+	}
+	st, err = d.GetState()
+	if !errors.Is(err, utm.ErrVMNotFound) || st != state.Error {
+		t.Fatalf("expected error state for a missing vm, got %s, %v", st, err)
+	}
+}
+
+func TestDriverStartRunning(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+	d := newTestDriver(t, backend)
+
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range backend.Scripts() {
+		if slices.Contains(script, "start vm") {
+			t.Fatal("expected a running vm not to be started again")
+		}
+	}
+}
+
+func TestDriverCopyGuest(t *testing.T) {
+	backend := utmtest.New()
+	backend.AddVM("docker-machine-test", utm.VmStatusStarted)
+	d := newTestDriver(t, backend)
+
+	src := d.ResolveStorePath("src")
+	if err := os.WriteFile(src, []byte("daemon.json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CopyToGuest(src, "/etc/docker/daemon.json"); err != nil {
+		t.Fatal(err)
+	}
+	if vm, _ := backend.VM("docker-machine-test"); string(vm.Files["/etc/docker/daemon.json"]) != "daemon.json" {
+		t.Fatalf("unexpected guest files: %q", vm.Files)
+	}
+
+	dst := d.ResolveStorePath("dst")
+	if err := d.CopyFromGuest("/etc/docker/daemon.json", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "daemon.json" {
+		t.Fatalf("unexpected copied contents %q", b)
+	}
+
+	if err := d.CopyFromGuest("/missing", dst+".missing"); err == nil {
+		t.Fatal("expected copying a missing file to fail")
+	}
+}
@@ -37,15 +37,12 @@ func (m *marshaler) marshalStruct(val reflect.Value) error {
 		field := typ.Field(i)
 		fieldVal := val.Field(i)
 
-		tag := field.Tag.Get("applescript")
+		tag, keepEmpty := fieldTag(field)
 		if tag == "-" {
 			continue
 		}
-		if tag == "" {
-			tag = field.Name
-		}
 
-		if isEmpty(fieldVal) {
+		if !keepEmpty && isEmpty(fieldVal) {
 			continue
 		}
 
@@ -90,7 +87,7 @@ func (m *marshaler) marshalField(fieldName string, fieldVal reflect.Value) error
 
 	switch fieldVal.Type() {
 	case reflect.TypeOf(""):
-		m.buf.WriteString(fmt.Sprintf("%q", fieldVal.String()))
+		m.buf.WriteString(Quote(fieldVal.String()))
 	case reflect.TypeOf(int(0)), reflect.TypeOf(int8(0)), reflect.TypeOf(int16(0)), reflect.TypeOf(int32(0)), reflect.TypeOf(int64(0)):
 		m.buf.WriteString(fmt.Sprintf("%d", fieldVal.Int()))
 	case reflect.TypeOf(float32(0)), reflect.TypeOf(float64(0)):
@@ -100,6 +97,11 @@ func (m *marshaler) marshalField(fieldName string, fieldVal reflect.Value) error
 	default:
 		switch fieldVal.Type().Kind() {
 		case reflect.String:
+			// Enumerated constants are written as is, so anything else
+			// could inject code into the script.
+			if !identPattern.MatchString(fieldVal.String()) {
+				return fmt.Errorf("invalid %s value %q", fieldVal.Type().Name(), fieldVal.String())
+			}
 			m.buf.WriteString(fieldVal.String())
 		case reflect.Struct:
 			return m.marshalStruct(fieldVal)
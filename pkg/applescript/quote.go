@@ -0,0 +1,83 @@
+package applescript
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quote returns s as an AppleScript text expression that evaluates to s.
+// Quotes and backslashes are escaped, and control characters without an
+// escape sequence are spliced in with `character id`, so the result can be
+// embedded in a script without changing its meaning. Invalid UTF-8 is
+// replaced with U+FFFD, as AppleScript text is Unicode.
+func Quote(s string) string {
+	q, _ := quote(s)
+	return q
+}
+
+// quoteOperand is Quote for text that follows a keyword, as in
+// `POSIX file "…"`. A concatenation is parenthesized there, as the keyword
+// would otherwise only apply to its first literal.
+func quoteOperand(s string) string {
+	q, spliced := quote(s)
+	if spliced {
+		return "(" + q + ")"
+	}
+	return q
+}
+
+// quote returns Quote(s) and whether it spliced in characters, making the
+// result a concatenation rather than a single literal.
+func quote(s string) (string, bool) {
+	spliced := false
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range strings.ToValidUTF8(s, "�") {
+		switch r {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `" & (character id %d) & "`, r)
+				spliced = true
+				continue
+			}
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String(), spliced
+}
+
+// Unquote parses a text expression as produced by Quote, possibly in
+// parentheses, or printed by `osascript -s s` and returns the text it
+// evaluates to.
+func Unquote(s string) (string, error) {
+	p := &parser{data: []rune(s)}
+	p.skipSpace()
+	paren := p.consume("(")
+	p.skipSpace()
+	if p.peek() != '"' {
+		return "", fmt.Errorf("expected text at offset %d", p.pos)
+	}
+	text, err := p.parseText()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace()
+	if paren && !p.consume(")") {
+		return "", fmt.Errorf("expected ) at offset %d", p.pos)
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return "", fmt.Errorf("unexpected %q at offset %d", p.data[p.pos], p.pos)
+	}
+	return text.(string), nil
+}
@@ -0,0 +1,141 @@
+package applescript
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{``, `""`},
+		{`docker-machine-test`, `"docker-machine-test"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\path`, `"C:\\path"`},
+		{"a\nb\tc\r", `"a\nb\tc\r"`},
+		{"café ☕", `"café ☕"`},
+		{"esc\x1b[0m", `"esc" & (character id 27) & "[0m"`},
+		{"bad\xffutf8", `"bad` + "\uFFFD" + `utf8"`},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteOperand(t *testing.T) {
+	if got := quoteOperand("a b"); got != `"a b"` {
+		t.Fatalf("unexpected operand %s", got)
+	}
+	got := quoteOperand("a\x01b")
+	if got != `("a" & (character id 1) & "b")` {
+		t.Fatalf("unexpected operand %s", got)
+	}
+	if text, err := Unquote(got); err != nil || text != "a\x01b" {
+		t.Fatalf("Unquote(%s) = %q, %v", got, text, err)
+	}
+}
+
+func TestUnquoteRejectsTrailingCode(t *testing.T) {
+	for _, s := range []string{
+		`"a" & (do shell script "id")`,
+		`"a" do shell script "id"`,
+		`"a`,
+		`a`,
+		`("a"`,
+		`("a") & "b"`,
+	} {
+		if _, err := Unquote(s); err == nil {
+			t.Errorf("Unquote(%s): expected an error", s)
+		}
+	}
+}
+
+func FuzzQuote(f *testing.F) {
+	for _, s := range []string{"", `a"b`, `a\b`, "\" & (do shell script \"id\") & \"", "\x00\x1b\x7f", "line\nbreak", "é\xff"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		quoted := Quote(s)
+		if !utf8.ValidString(quoted) {
+			t.Fatalf("Quote(%q) is not valid UTF-8", s)
+		}
+		if strings.ContainsAny(quoted, "\n\r") {
+			t.Fatalf("Quote(%q) spans several lines: %s", s, quoted)
+		}
+
+		want := strings.ToValidUTF8(s, "\uFFFD")
+		got, err := Unquote(quoted)
+		if err != nil {
+			t.Fatalf("Unquote(%s): %v", quoted, err)
+		}
+		if got != want {
+			t.Fatalf("round trip of %q gave %q", want, got)
+		}
+
+		// Embedded in a record, the literal must not leak into its neighbours.
+		var rec struct {
+			Name  string `applescript:"name"`
+			Notes string `applescript:"notes"`
+		}
+		if err := Unmarshal([]byte(`{name:`+quoted+`, notes:"end"}`), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Name != want || rec.Notes != "end" {
+			t.Fatalf("record round trip of %q gave %+v", want, rec)
+		}
+
+		// After a specifier keyword, the whole text must be the operand.
+		checkOperand(t, PosixFile(s), "POSIX file", want)
+		checkOperand(t, ID("virtual machine", s), "virtual machine id", want)
+		checkOperand(t, Named("virtual machine", s), "virtual machine named", want)
+	})
+}
+
+// checkOperand verifies that the specifier e applies keyword to text that
+// evaluates to want: a single literal, or a parenthesized concatenation.
+func checkOperand(t *testing.T, e Expr, keyword, want string) {
+	t.Helper()
+	rendered := e.expr()
+	operand, ok := strings.CutPrefix(rendered, keyword+" ")
+	if !ok {
+		t.Fatalf("%s does not start with %s", rendered, keyword)
+	}
+	if !strings.HasPrefix(operand, "(") {
+		p := &parser{data: []rune(operand)}
+		if _, err := p.parseString(); err != nil || !p.eof() {
+			t.Fatalf("operand of %s is neither a single literal nor parenthesized", rendered)
+		}
+	}
+	got, err := Unquote(operand)
+	if err != nil {
+		t.Fatalf("Unquote(%s): %v", operand, err)
+	}
+	if got != want {
+		t.Fatalf("%s refers to %q, want %q", rendered, got, want)
+	}
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add("docker-machine-test", "notes", 1024)
+	f.Add(`"}, memory: 0, name: "x`, "\\", -1)
+	f.Fuzz(func(t *testing.T, name, notes string, memory int) {
+		in := testConf{Name: name, Notes: notes, Memory: memory}
+		data, err := Marshal(&in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out testConf
+		if err := Unmarshal(data, &out); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", data, err)
+		}
+		want := testConf{Name: strings.ToValidUTF8(name, "\uFFFD"), Notes: strings.ToValidUTF8(notes, "\uFFFD"), Memory: memory}
+		if out.Name != want.Name || out.Notes != want.Notes || out.Memory != want.Memory {
+			t.Fatalf("round trip of %+v gave %+v", want, out)
+		}
+	})
+}
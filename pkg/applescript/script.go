@@ -0,0 +1,235 @@
+package applescript
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Expr is an AppleScript expression. Values built from Go data, such as
+// String or PosixFile, are always quoted, so they cannot change the meaning
+// of the script they are part of.
+type Expr interface {
+	expr() string
+}
+
+// Stmt is an AppleScript statement.
+type Stmt interface {
+	lines() []string
+}
+
+// String is a text literal.
+type String string
+
+func (s String) expr() string { return Quote(string(s)) }
+
+// Int is an integer literal.
+type Int int
+
+func (i Int) expr() string { return strconv.Itoa(int(i)) }
+
+// Bool is a boolean literal.
+type Bool bool
+
+func (b Bool) expr() string { return strconv.FormatBool(bool(b)) }
+
+// Ident names a variable, a property or an enumerated constant such as
+// `qemu`. It is written as is and must never hold user input.
+type Ident string
+
+func (i Ident) expr() string { return string(i) }
+
+type rawExpr string
+
+func (r rawExpr) expr() string { return string(r) }
+
+// PosixFile is a file reference to a POSIX path.
+func PosixFile(path string) Expr {
+	return rawExpr("POSIX file " + quoteOperand(path))
+}
+
+// ID references an element by its id, as in `virtual machine id "…"`.
+func ID(class, id string) Expr {
+	return rawExpr(class + " id " + quoteOperand(id))
+}
+
+// IDOf references an element by an id of any type, as in `guest process id 42`.
+func IDOf(class string, id Expr) Expr {
+	return rawExpr(class + " id " + id.expr())
+}
+
+// Named references an element by its name, as in `virtual machine named "…"`.
+func Named(class, name string) Expr {
+	return rawExpr(class + " named " + quoteOperand(name))
+}
+
+// Of references a property or element of an object, as in `status of vm`.
+// Commands are parenthesized.
+func Of(property string, obj Expr) Expr {
+	if _, ok := obj.(Command); ok {
+		obj = Paren(obj)
+	}
+	return rawExpr(property + " of " + obj.expr())
+}
+
+// Elem references an element of a container, as in `guest process id 42 of vm`.
+func Elem(ref, container Expr) Expr {
+	return rawExpr(ref.expr() + " of " + container.expr())
+}
+
+// Paren groups an expression, as needed around a command used as a value.
+func Paren(e Expr) Expr {
+	return rawExpr("(" + e.expr() + ")")
+}
+
+// Item references the n-th item of a list, counting from 1.
+func Item(n int, list Expr) Expr {
+	return Of("item "+strconv.Itoa(n), list)
+}
+
+// List is a list of expressions.
+func List(items ...Expr) Expr {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = item.expr()
+	}
+	return rawExpr("{" + strings.Join(parts, ", ") + "}")
+}
+
+// Strings is a list of text literals.
+func Strings(items ...string) Expr {
+	exprs := make([]Expr, len(items))
+	for i, item := range items {
+		exprs[i] = String(item)
+	}
+	return List(exprs...)
+}
+
+// Field is a labelled value of a record.
+type Field struct {
+	Key   string
+	Value Expr
+}
+
+// Record is a record literal with the fields in order.
+func Record(fields ...Field) Expr {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field.Key + ": " + field.Value.expr()
+	}
+	return rawExpr("{" + strings.Join(parts, ", ") + "}")
+}
+
+// RecordOf marshals a struct into a record literal.
+func RecordOf(v any) (Expr, error) {
+	res, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return rawExpr(res), nil
+}
+
+// Param is a labelled parameter of a command, as in `with arguments {…}`.
+// A Param without a Value is a boolean parameter set to true; these are
+// rendered last, as in `with base64 encoding and output capturing`.
+type Param struct {
+	Label string
+	Value Expr
+}
+
+// Command sends a command with an optional direct parameter and labelled
+// parameters, as in `stop vm by request`. It can be used both as a statement
+// and as an expression.
+type Command struct {
+	Name   string
+	Direct Expr
+	Params []Param
+}
+
+// Cmd is a shorthand for building a Command.
+func Cmd(name string, direct Expr, params ...Param) Command {
+	return Command{Name: name, Direct: direct, Params: params}
+}
+
+func (c Command) expr() string {
+	parts := []string{c.Name}
+	if c.Direct != nil {
+		parts = append(parts, c.Direct.expr())
+	}
+	var flags []string
+	for _, p := range c.Params {
+		if p.Value == nil {
+			flags = append(flags, p.Label)
+			continue
+		}
+		parts = append(parts, p.Label, p.Value.expr())
+	}
+	if len(flags) > 0 {
+		parts = append(parts, "with", strings.Join(flags, " and "))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c Command) lines() []string { return []string{c.expr()} }
+
+type simpleStmt string
+
+func (s simpleStmt) lines() []string { return []string{string(s)} }
+
+// Set assigns value to target, which is usually an Ident.
+func Set(target, value Expr) Stmt {
+	return simpleStmt("set " + target.expr() + " to " + value.expr())
+}
+
+// Return ends the script with the value of e.
+func Return(e Expr) Stmt {
+	return simpleStmt("return " + e.expr())
+}
+
+type block struct {
+	open, close string
+	body        []Stmt
+}
+
+func (b block) lines() []string {
+	lines := []string{b.open}
+	for _, stmt := range b.body {
+		for _, line := range stmt.lines() {
+			lines = append(lines, "\t"+line)
+		}
+	}
+	return append(lines, b.close)
+}
+
+// Repeat runs body once for every item of list, bound to the variable v.
+func Repeat(v Ident, list Expr, body ...Stmt) Stmt {
+	return block{open: "repeat with " + v.expr() + " in " + list.expr(), close: "end repeat", body: body}
+}
+
+// Tell runs body in the context of an application.
+func Tell(app string, body ...Stmt) Stmt {
+	return block{open: "tell application " + quoteOperand(app), close: "end tell", body: body}
+}
+
+// Source inserts statements that are already rendered, such as the lines
+// handed to a script runner.
+func Source(lines ...string) Stmt {
+	return sourceLines(lines)
+}
+
+type sourceLines []string
+
+func (s sourceLines) lines() []string { return s }
+
+// Lines renders statements, one line per element.
+func Lines(stmts ...Stmt) []string {
+	var lines []string
+	for _, stmt := range stmts {
+		lines = append(lines, stmt.lines()...)
+	}
+	return lines
+}
+
+// Render renders statements as a script.
+func Render(stmts ...Stmt) string {
+	return strings.Join(Lines(stmts...), "\n")
+}
@@ -0,0 +1,34 @@
+package applescript
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	vm := Ident("vm")
+	got := Render(Tell("UTM",
+		Set(vm, Named("virtual machine", `a "b"`)),
+		Repeat(Ident("f"), List(Int(1), Bool(true), String("x")),
+			Cmd("log", Ident("f")),
+		),
+		Cmd("execute of", vm, Param{Label: "at", Value: String("ls")}, Param{Label: "output capturing"}, Param{Label: "with arguments", Value: Strings()}, Param{Label: "base64 encoding"}),
+		Cmd("get result of", Paren(Elem(IDOf("guest process", Int(42)), vm))),
+		Return(Record(
+			Field{Key: "ip", Value: Item(1, Cmd("query ip of", vm))},
+			Field{Key: "args", Value: Strings("-c", `echo "\"`)},
+			Field{Key: "file", Value: PosixFile("/tmp/a b")},
+			Field{Key: "ctl", Value: PosixFile("/tmp/a\x01b")},
+			Field{Key: "vm", Value: ID("virtual machine", "a\x1bb")},
+		)),
+	))
+	want := `tell application "UTM"
+	set vm to virtual machine named "a \"b\""
+	repeat with f in {1, true, "x"}
+		log f
+	end repeat
+	execute of vm at "ls" with arguments {} with output capturing and base64 encoding
+	get result of (guest process id 42 of vm)
+	return {ip: item 1 of (query ip of vm), args: {"-c", "echo \"\\\""}, file: POSIX file "/tmp/a b", ctl: POSIX file ("/tmp/a" & (character id 1) & "b"), vm: virtual machine id ("a" & (character id 27) & "b")}
+end tell`
+	if got != want {
+		t.Fatalf("unexpected script:\n%s\nwant:\n%s", got, want)
+	}
+}
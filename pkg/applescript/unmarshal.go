@@ -0,0 +1,495 @@
+package applescript
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Unmarshal parses AppleScript values in source form (as printed by
+// `osascript -s s`) and stores the result in the value pointed to by v.
+// Records decode into structs using the same `applescript` tags as Marshal,
+// lists decode into slices, and enumerations decode into string kinds.
+func Unmarshal(data []byte, v any) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return fmt.Errorf("unmarshal target must be a non-nil pointer: %T", v)
+	}
+
+	p := &parser{data: []rune(string(data))}
+	p.skipSpace()
+	if p.eof() {
+		return nil
+	}
+	parsed, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return fmt.Errorf("unexpected %q at offset %d", p.data[p.pos], p.pos)
+	}
+
+	return assign(parsed, val.Elem())
+}
+
+type recordField struct {
+	key   string
+	value any
+}
+
+type record []recordField
+
+type list []any
+
+type enum string
+
+type missingValue struct{}
+
+type parser struct {
+	data []rune
+	pos  int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *parser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.data[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *parser) parseValue() (any, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, fmt.Errorf("unexpected end of input")
+	}
+
+	switch c := p.peek(); {
+	case c == '{':
+		return p.parseCollection()
+	case c == '"':
+		return p.parseText()
+	case c == '-' || c == '+' || c == '.' || unicode.IsDigit(c):
+		return p.parseNumber()
+	case c == '«':
+		return p.parseRaw()
+	case isWordRune(c):
+		return p.parseWord()
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+	}
+}
+
+func (p *parser) parseCollection() (any, error) {
+	p.pos++
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.pos++
+		return list{}, nil
+	}
+
+	start := p.pos
+	if _, ok := p.parseKey(); ok {
+		p.pos = start
+		return p.parseRecord()
+	}
+	p.pos = start
+	return p.parseList()
+}
+
+func (p *parser) parseRecord() (any, error) {
+	rec := record{}
+	for {
+		p.skipSpace()
+		key, ok := p.parseKey()
+		if !ok {
+			return nil, fmt.Errorf("expected record key at offset %d", p.pos)
+		}
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		rec = append(rec, recordField{key: key, value: val})
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return rec, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or '}' at offset %d", p.pos)
+		}
+	}
+}
+
+func (p *parser) parseList() (any, error) {
+	l := list{}
+	for {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, val)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return l, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or '}' at offset %d", p.pos)
+		}
+	}
+}
+
+// parseKey consumes a record key and the colon that follows it.
+func (p *parser) parseKey() (string, bool) {
+	var key string
+	if p.peek() == '|' {
+		end := p.pos + 1
+		for end < len(p.data) && p.data[end] != '|' {
+			end++
+		}
+		if end >= len(p.data) {
+			return "", false
+		}
+		key = string(p.data[p.pos+1 : end])
+		p.pos = end + 1
+	} else {
+		start := p.pos
+		for !p.eof() && (isWordRune(p.peek()) || p.peek() == ' ') {
+			p.pos++
+		}
+		key = strings.TrimSpace(string(p.data[start:p.pos]))
+		if key == "" {
+			return "", false
+		}
+	}
+
+	p.skipSpace()
+	if p.peek() != ':' {
+		return "", false
+	}
+	p.pos++
+	return key, true
+}
+
+func (p *parser) parseString() (any, error) {
+	p.pos++
+	var sb strings.Builder
+	for !p.eof() {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.eof() {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				sb.WriteRune('\n')
+			case 'r':
+				sb.WriteRune('\r')
+			case 't':
+				sb.WriteRune('\t')
+			default:
+				sb.WriteRune(e)
+			}
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// parseText reads a string literal, or a concatenation of string literals
+// and `(character id N)` expressions as written by Quote.
+func (p *parser) parseText() (any, error) {
+	var sb strings.Builder
+	for {
+		p.skipSpace()
+		switch {
+		case p.peek() == '"':
+			s, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(s.(string))
+		case p.consume("(character id "):
+			start := p.pos
+			for !p.eof() && unicode.IsDigit(p.peek()) {
+				p.pos++
+			}
+			id, err := strconv.ParseInt(string(p.data[start:p.pos]), 10, 32)
+			if err != nil || !p.consume(")") {
+				return nil, fmt.Errorf("invalid character id at offset %d", start)
+			}
+			sb.WriteRune(rune(id))
+		default:
+			return nil, fmt.Errorf("expected text at offset %d", p.pos)
+		}
+
+		p.skipSpace()
+		if p.peek() != '&' {
+			return sb.String(), nil
+		}
+		p.pos++
+	}
+}
+
+// consume advances past prefix if the input continues with it.
+func (p *parser) consume(prefix string) bool {
+	r := []rune(prefix)
+	if len(p.data)-p.pos < len(r) || string(p.data[p.pos:p.pos+len(r)]) != prefix {
+		return false
+	}
+	p.pos += len(r)
+	return true
+}
+
+func (p *parser) parseNumber() (any, error) {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if unicode.IsDigit(c) || strings.ContainsRune("+-.eE", c) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	text := string(p.data[start:p.pos])
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q at offset %d", text, start)
+	}
+	return f, nil
+}
+
+func (p *parser) parseRaw() (any, error) {
+	start := p.pos
+	for !p.eof() && p.peek() != '»' {
+		p.pos++
+	}
+	if p.eof() {
+		return nil, fmt.Errorf("unterminated raw code at offset %d", start)
+	}
+	p.pos++
+	raw := string(p.data[start:p.pos])
+
+	p.skipSpace()
+	if p.peek() == '"' {
+		return p.parseString()
+	}
+	return enum(raw), nil
+}
+
+// parseWord reads constants such as `true`, `missing value` or enumerations,
+// as well as file references like `file "Macintosh HD:path"`.
+func (p *parser) parseWord() (any, error) {
+	start := p.pos
+	for !p.eof() && (isWordRune(p.peek()) || p.peek() == ' ') {
+		p.pos++
+	}
+	word := strings.TrimSpace(string(p.data[start:p.pos]))
+
+	if p.peek() == '"' {
+		return p.parseString()
+	}
+
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "missing value", "null":
+		return missingValue{}, nil
+	}
+	return enum(word), nil
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_'
+}
+
+func assign(src any, dst reflect.Value) error {
+	if _, ok := src.(missingValue); ok {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(src, dst.Elem())
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return fmt.Errorf("unsupported type: %s", dst.Type())
+		}
+		dst.Set(reflect.ValueOf(generic(src)))
+		return nil
+	}
+
+	switch v := src.(type) {
+	case string:
+		if dst.Kind() != reflect.String {
+			return fmt.Errorf("cannot decode string into %s", dst.Type())
+		}
+		dst.SetString(v)
+	case enum:
+		if dst.Kind() != reflect.String {
+			return fmt.Errorf("cannot decode constant %s into %s", v, dst.Type())
+		}
+		dst.SetString(string(v))
+	case bool:
+		if dst.Kind() != reflect.Bool {
+			return fmt.Errorf("cannot decode boolean into %s", dst.Type())
+		}
+		dst.SetBool(v)
+	case int64:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(v) {
+				return fmt.Errorf("number %d overflows %s", v, dst.Type())
+			}
+			dst.SetInt(v)
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(v))
+		default:
+			return fmt.Errorf("cannot decode number into %s", dst.Type())
+		}
+	case float64:
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v != float64(int64(v)) || dst.OverflowInt(int64(v)) {
+				return fmt.Errorf("cannot decode number %v into %s", v, dst.Type())
+			}
+			dst.SetInt(int64(v))
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(v)
+		default:
+			return fmt.Errorf("cannot decode number into %s", dst.Type())
+		}
+	case list:
+		return assignList(v, dst)
+	case record:
+		return assignRecord(v, dst)
+	default:
+		return fmt.Errorf("unsupported value: %v", src)
+	}
+	return nil
+}
+
+func assignList(l list, dst reflect.Value) error {
+	switch dst.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(dst.Type(), len(l), len(l))
+		for i, item := range l {
+			if err := assign(item, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+	case reflect.Struct:
+		// An empty record is printed as an empty list.
+		if len(l) == 0 {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+	case reflect.Map:
+		if len(l) == 0 {
+			dst.Set(reflect.MakeMap(dst.Type()))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot decode list into %s", dst.Type())
+}
+
+func assignRecord(rec record, dst reflect.Value) error {
+	switch dst.Kind() {
+	case reflect.Struct:
+		typ := dst.Type()
+		for _, f := range rec {
+			for i := 0; i < typ.NumField(); i++ {
+				field := typ.Field(i)
+				if !field.IsExported() {
+					continue
+				}
+				tag, _ := fieldTag(field)
+				if tag == "-" {
+					continue
+				}
+				if !strings.EqualFold(tag, f.key) {
+					continue
+				}
+				if err := assign(f.value, dst.Field(i)); err != nil {
+					return fmt.Errorf("%s: %w", f.key, err)
+				}
+				break
+			}
+		}
+		return nil
+	case reflect.Map:
+		if dst.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type: %s", dst.Type().Key())
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), len(rec))
+		for _, f := range rec {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := assign(f.value, elem); err != nil {
+				return fmt.Errorf("%s: %w", f.key, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(f.key).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(m)
+		return nil
+	}
+	return fmt.Errorf("cannot decode record into %s", dst.Type())
+}
+
+func generic(src any) any {
+	switch v := src.(type) {
+	case enum:
+		return string(v)
+	case list:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = generic(item)
+		}
+		return out
+	case record:
+		out := make(map[string]any, len(v))
+		for _, f := range v {
+			out[f.key] = generic(f.value)
+		}
+		return out
+	case missingValue:
+		return nil
+	}
+	return src
+}
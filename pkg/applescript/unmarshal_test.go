@@ -0,0 +1,101 @@
+package applescript
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testDrive struct {
+	ID        string `applescript:"id"`
+	Removable bool   `applescript:"removable"`
+	Interface string `applescript:"interface"`
+	GuestSize int    `applescript:"guest size"`
+}
+
+type testConf struct {
+	Name   string      `applescript:"name"`
+	Notes  string      `applescript:"notes"`
+	Memory int         `applescript:"memory"`
+	CPU    int         `applescript:"cpu cores"`
+	Ratio  float64     `applescript:"ratio"`
+	UEFI   bool        `applescript:"uefi"`
+	Drives []testDrive `applescript:"drives"`
+	Tags   []string    `applescript:"tags"`
+}
+
+func TestUnmarshalRecord(t *testing.T) {
+	data := `{name:"docker \"machine\"", notes:missing value, memory:2048, cpu cores:2, ratio:1.5, uefi:true, ` +
+		`drives:{{id:"A-1", removable:true, interface:IDE, guest size:0}, {id:"B-2", removable:false, interface:VirtIO, guest size:8192}}, ` +
+		`tags:{"a", "b"}, unknown:{1, 2}}`
+
+	var conf testConf
+	if err := Unmarshal([]byte(data), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := testConf{
+		Name:   `docker "machine"`,
+		Memory: 2048,
+		CPU:    2,
+		Ratio:  1.5,
+		UEFI:   true,
+		Drives: []testDrive{
+			{ID: "A-1", Removable: true, Interface: "IDE"},
+			{ID: "B-2", Interface: "VirtIO", GuestSize: 8192},
+		},
+		Tags: []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Fatalf("got %+v\nwant %+v", conf, want)
+	}
+}
+
+func TestUnmarshalScalars(t *testing.T) {
+	var s string
+	if err := Unmarshal([]byte(`stopped`), &s); err != nil || s != "stopped" {
+		t.Fatalf("enum: %q, %v", s, err)
+	}
+	if err := Unmarshal([]byte(`"192.168.64.2"`), &s); err != nil || s != "192.168.64.2" {
+		t.Fatalf("string: %q, %v", s, err)
+	}
+	if err := Unmarshal([]byte(`file "Macintosh HD:tmp:a.iso"`), &s); err != nil || s != "Macintosh HD:tmp:a.iso" {
+		t.Fatalf("file: %q, %v", s, err)
+	}
+
+	var n int
+	if err := Unmarshal([]byte(`-42`), &n); err != nil || n != -42 {
+		t.Fatalf("int: %d, %v", n, err)
+	}
+
+	var l []string
+	if err := Unmarshal([]byte(`{}`), &l); err != nil || len(l) != 0 {
+		t.Fatalf("empty list: %v, %v", l, err)
+	}
+
+	var v any
+	if err := Unmarshal([]byte(`{a:{1, "x", true}, |b c|:qemu}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"a": []any{int64(1), "x", true}, "b c": "qemu"}
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v\nwant %#v", v, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var conf testConf
+	for _, data := range []string{
+		`{name:"unterminated}`,
+		`{memory:"lots"}`,
+		`{name:"a"} trailing`,
+		`{name:"a",}`,
+	} {
+		if err := Unmarshal([]byte(data), &conf); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+
+	if err := Unmarshal([]byte(`"a"`), conf); err == nil {
+		t.Error("expected error for non-pointer target")
+	}
+}
@@ -1,6 +1,13 @@
 package applescript
 
-import "reflect"
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// identPattern matches the enumerated constants Marshal writes unquoted.
+var identPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
 
 func isEmpty(v reflect.Value) bool {
 	switch v.Kind() {
@@ -21,3 +28,14 @@ func isEmpty(v reflect.Value) bool {
 	}
 	return false
 }
+
+// fieldTag returns the record key for a struct field. A "keepempty" option
+// makes Marshal write the field even when it holds its zero value.
+func fieldTag(field reflect.StructField) (name string, keepEmpty bool) {
+	tag := field.Tag.Get("applescript")
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, opts == "keepempty"
+}
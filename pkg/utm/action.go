@@ -3,38 +3,155 @@ package utm
 import (
 	"docker-machine-driver-utm/pkg/applescript"
 	"fmt"
-	"strings"
 )
 
+// vm is the variable that scripts bind the targeted virtual machine to.
+const vmVar = applescript.Ident("vm")
+
+// vmRecord reads the identifying properties of vm.
+var vmRecord = applescript.Record(
+	applescript.Field{Key: "id", Value: applescript.Of("id", vmVar)},
+	applescript.Field{Key: "name", Value: applescript.Of("name", vmVar)},
+	applescript.Field{Key: "backend", Value: applescript.Of("backend", vmVar)},
+	applescript.Field{Key: "status", Value: applescript.Of("status", vmVar)},
+)
+
+// setVM binds vm to the virtual machine with the given id.
+func setVM(id string) applescript.Stmt {
+	return applescript.Set(vmVar, applescript.ID("virtual machine", id))
+}
+
+func Version() (string, error) {
+	return DefaultClient.Version()
+}
+
 func ListVMs() ([]*VM, error) {
-	res, err := runUtmScript(
-		`set vms to virtual machines`,
-		`set output to ""`,
-		`repeat with vm in vms`,
-		`	set output to output & id of vm & "|#|" & name of vm & "|#|" & backend of vm & "|#|" & status of vm & "|&|"`,
-		`end repeat`,
-		`return output`,
+	return DefaultClient.ListVMs()
+}
+
+func GetVmByID(id string) (*VM, error) {
+	return DefaultClient.GetVmByID(id)
+}
+
+func GetVmByName(name string) (*VM, error) {
+	return DefaultClient.GetVmByName(name)
+}
+
+func CreateQemuVM(conf *QemuConf) (*VM, error) {
+	return DefaultClient.CreateQemuVM(conf)
+}
+
+func UpdateQemuVM(vm *VM, conf *QemuConf) error {
+	return vm.utm().UpdateQemuVM(vm, conf)
+}
+
+func DeleteVmByID(id string) error {
+	return DefaultClient.DeleteVmByID(id)
+}
+
+func DeleteVmByName(name string) error {
+	return DefaultClient.DeleteVmByName(name)
+}
+
+func CopyToVM(vm *VM, src, dst string) error {
+	return vm.utm().CopyToVM(vm, src, dst)
+}
+
+func CopyFromVM(vm *VM, src, dst string) error {
+	return vm.utm().CopyFromVM(vm, src, dst)
+}
+
+func RunCommandOnVM(vm *VM, cmd string, args ...string) error {
+	return vm.utm().RunCommandOnVM(vm, cmd, args...)
+}
+
+func (vm *VM) Start() error {
+	return vm.utm().Start(vm)
+}
+
+func (vm *VM) StartDisposable() error {
+	return vm.utm().StartDisposable(vm)
+}
+
+func (vm *VM) Pause() error {
+	return vm.utm().Pause(vm)
+}
+
+func (vm *VM) PauseAndSave() error {
+	return vm.utm().PauseAndSave(vm)
+}
+
+func (vm *VM) Stop() error {
+	return vm.utm().Stop(vm)
+}
+
+func (vm *VM) RequestStop() error {
+	return vm.utm().RequestStop(vm)
+}
+
+func (vm *VM) Shutdown() error {
+	return vm.utm().Shutdown(vm)
+}
+
+func (vm *VM) Kill() error {
+	return vm.utm().Kill(vm)
+}
+
+func (vm *VM) GetStatus() (VmStatus, error) {
+	return vm.utm().GetStatus(vm)
+}
+
+func (vm *VM) GetIP() (string, error) {
+	return vm.utm().GetIP(vm)
+}
+
+func (vm *VM) GetIPs() ([]string, error) {
+	return vm.utm().GetIPs(vm)
+}
+
+func (vm *VM) GetConfiguration() (*QemuConf, error) {
+	return vm.utm().GetConfiguration(vm)
+}
+
+// Version returns the version of UTM. It also serves as a probe that UTM is
+// installed and accepts Apple Events from this process.
+func (c *Client) Version() (string, error) {
+	res, err := c.runUtmScript(applescript.Return(applescript.Ident("version")))
+	if err != nil {
+		return "", err
+	}
+	var version string
+	if err := applescript.Unmarshal([]byte(res), &version); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	return version, nil
+}
+
+func (c *Client) ListVMs() ([]*VM, error) {
+	output := applescript.Ident("output")
+	res, err := c.runUtmScript(
+		applescript.Set(output, applescript.List()),
+		applescript.Repeat(vmVar, applescript.Ident("virtual machines"),
+			applescript.Set(applescript.Of("end", output), vmRecord),
+		),
+		applescript.Return(output),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	res = strings.TrimSuffix(res, "|&|")
-	lines := strings.Split(res, "|&|")
-	vms := make([]*VM, len(lines))
-	for i, line := range lines {
-		fields := strings.Split(line, "|#|")
-		if len(fields) != 4 {
-			continue
-		}
-
-		vms[i] = &VM{ID: fields[0], Name: fields[1], Backend: VmBackend(fields[2]), Status: VmStatus(fields[3])}
+	var vms []*VM
+	if err := applescript.Unmarshal([]byte(res), &vms); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	for _, vm := range vms {
+		c.bind(vm)
 	}
 	return vms, nil
 }
 
-func GetVmByID(id string) (*VM, error) {
-	vms, err := ListVMs()
+func (c *Client) GetVmByID(id string) (*VM, error) {
+	vms, err := c.ListVMs()
 	if err != nil {
 		return nil, err
 	}
@@ -43,11 +160,11 @@ func GetVmByID(id string) (*VM, error) {
 			return vm, nil
 		}
 	}
-	return nil, fmt.Errorf("vm not found")
+	return nil, fmt.Errorf("%w: id %s", ErrVMNotFound, id)
 }
 
-func GetVmByName(name string) (*VM, error) {
-	vms, err := ListVMs()
+func (c *Client) GetVmByName(name string) (*VM, error) {
+	vms, err := c.ListVMs()
 	if err != nil {
 		return nil, err
 	}
@@ -56,187 +173,259 @@ func GetVmByName(name string) (*VM, error) {
 			return vm, nil
 		}
 	}
-	return nil, fmt.Errorf("vm not found")
+	return nil, fmt.Errorf("%w: %s", ErrVMNotFound, name)
 }
 
-func (vm *VM) Start() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`start vm`,
+func (c *Client) Start(vm *VM) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("start", vmVar),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (vm *VM) StartDisposable() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`start vm without saving`,
+func (c *Client) StartDisposable(vm *VM) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("start", vmVar, applescript.Param{Label: "without", Value: applescript.Ident("saving")}),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (vm *VM) Pause() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`suspend vm`,
+func (c *Client) Pause(vm *VM) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("suspend", vmVar),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (vm *VM) PauseAndSave() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`suspend vm with saving`,
+func (c *Client) PauseAndSave(vm *VM) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("suspend", vmVar, applescript.Param{Label: "with", Value: applescript.Ident("saving")}),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (vm *VM) Stop() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`stop vm`,
+func (c *Client) Stop(vm *VM) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("stop", vmVar),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (vm *VM) Shutdown() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`stop vm by force`,
+// RequestStop asks the guest to shut itself down, like pressing the power
+// button. It returns as soon as the request is delivered.
+func (c *Client) RequestStop(vm *VM) error {
+	return c.stop(vm, "request")
+}
+
+func (c *Client) Shutdown(vm *VM) error {
+	return c.stop(vm, "force")
+}
+
+func (c *Client) Kill(vm *VM) error {
+	return c.stop(vm, "kill")
+}
+
+func (c *Client) stop(vm *VM, method applescript.Ident) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("stop", vmVar, applescript.Param{Label: "by", Value: method}),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
 
-func (vm *VM) Kill() error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`stop vm by kill`,
+func (c *Client) GetStatus(vm *VM) (VmStatus, error) {
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Return(applescript.Of("status", vmVar)),
 	)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
+	var status VmStatus
+	if err := applescript.Unmarshal([]byte(res), &status); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	return status, nil
 }
 
-func (vm *VM) GetStatus() (VmStatus, error) {
-	res, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`return status of vm`,
+func (c *Client) GetIP(vm *VM) (string, error) {
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Return(applescript.Item(1, applescript.Cmd("query ip of", vmVar))),
 	)
 	if err != nil {
 		return "", err
 	}
-	return VmStatus(res), nil
+	var ip string
+	if err := applescript.Unmarshal([]byte(res), &ip); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	return ip, nil
 }
 
-func (vm *VM) GetIP() (string, error) {
-	res, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		`return item 1 of (query ip of vm)`,
+// GetIPs returns every address the guest agent reports, IPv4 and IPv6, in
+// the order of the guest's interfaces.
+func (c *Client) GetIPs(vm *VM) ([]string, error) {
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Return(applescript.Cmd("query ip of", vmVar)),
 	)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	var ips []string
+	if err := applescript.Unmarshal([]byte(res), &ips); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
 	}
-	return res, nil
+	return ips, nil
 }
 
-func CreateQemuVM(conf *QemuConf) (*VM, error) {
-	type sourceFiles struct {
-		name string
-		path string
+func (c *Client) GetConfiguration(vm *VM) (*QemuConf, error) {
+	if vm.Backend != "" && vm.Backend != VmBackendQemu {
+		return nil, fmt.Errorf("unsupported backend: %s", vm.Backend)
 	}
-	files := []sourceFiles{}
-	for i, drive := range conf.Drives {
-		if drive.Source != "" {
-			name := fmt.Sprintf("drive%d", i)
-			files = append(files, sourceFiles{name: name, path: string(drive.Source)})
-			conf.Drives[i].Source = QemuDriveSource(name)
-		}
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Return(applescript.Of("configuration", vmVar)),
+	)
+	if err != nil {
+		return nil, err
 	}
-	cmds := []string{}
-	for _, file := range files {
-		cmds = append(cmds, fmt.Sprintf(`set %s to POSIX file "%s"`, file.name, file.path))
+	conf := &QemuConf{}
+	if err := applescript.Unmarshal([]byte(res), conf); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
 	}
+	return conf, nil
+}
 
-	res, err := applescript.Marshal(conf)
+func (c *Client) CreateQemuVM(conf *QemuConf) (*VM, error) {
+	script := driveSources(conf.Drives)
+	create, err := makeVM(VmBackendQemu, conf)
 	if err != nil {
 		return nil, err
 	}
-	output, err := runUtmScript(
-		strings.Join(cmds, "\n"),
-		fmt.Sprintf(
-			`set vm to make new virtual machine with properties {backend: qemu, configuration: %s}`, string(res)),
-		`set output to ""`,
-		`set output to id of vm & "|#|" & name of vm & "|#|" & backend of vm & "|#|" & status of vm`,
-		`return output`,
-	)
+	output, err := c.runUtmScript(append(script, create, applescript.Return(vmRecord))...)
 	if err != nil {
 		return nil, err
 	}
-	fields := strings.Split(output, "|#|")
-	if len(fields) != 4 {
-		return nil, fmt.Errorf("invalid response: %s", output)
+
+	vm := &VM{}
+	if err := applescript.Unmarshal([]byte(output), vm); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return c.bind(vm), nil
+}
+
+// UpdateQemuVM merges conf into the current configuration of a stopped VM.
+// Zero values leave the current setting untouched. Drives are matched by ID
+// and network interfaces by index; entries without a match are added.
+func (c *Client) UpdateQemuVM(vm *VM, conf *QemuConf) error {
+	current, err := c.GetConfiguration(vm)
+	if err != nil {
+		return err
 	}
+	merged := mergeQemuConf(current, conf)
+	script := driveSources(merged.Drives)
 
-	return &VM{
-		ID:      fields[0],
-		Name:    fields[1],
-		Backend: VmBackend(fields[2]),
-		Status:  VmStatus(fields[3]),
-	}, nil
+	update, err := updateVM(merged)
+	if err != nil {
+		return err
+	}
+	_, err = c.runUtmScript(append(script, setVM(vm.ID), update)...)
+	return err
 }
 
-func DeleteVmByID(id string) error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`delete virtual machine id "%s"`, id),
+// makeVM creates a virtual machine from conf and binds it to vm.
+func makeVM(backend VmBackend, conf any) (applescript.Stmt, error) {
+	record, err := applescript.RecordOf(conf)
+	if err != nil {
+		return nil, err
+	}
+	return applescript.Set(vmVar, applescript.Cmd("make new virtual machine", nil, applescript.Param{
+		Label: "with properties",
+		Value: applescript.Record(
+			applescript.Field{Key: "backend", Value: applescript.Ident(backend)},
+			applescript.Field{Key: "configuration", Value: record},
+		),
+	})), nil
+}
+
+// updateVM replaces the configuration of vm with conf.
+func updateVM(conf any) (applescript.Stmt, error) {
+	record, err := applescript.RecordOf(conf)
+	if err != nil {
+		return nil, err
+	}
+	return applescript.Cmd("update configuration of", vmVar, applescript.Param{Label: "to", Value: record}), nil
+}
+
+// driveSources binds the source of every drive to a file variable and points
+// the drive at it, since configurations only accept files by reference.
+func driveSources(drives []QemuDriveConf) []applescript.Stmt {
+	script := []applescript.Stmt{}
+	for i, drive := range drives {
+		if drive.Source != "" {
+			name := fmt.Sprintf("drive%d", i)
+			script = append(script, applescript.Set(applescript.Ident(name), applescript.PosixFile(string(drive.Source))))
+			drives[i].Source = QemuDriveSource(name)
+		}
+	}
+	return script
+}
+
+func (c *Client) DeleteVmByID(id string) error {
+	_, err := c.runUtmScript(
+		applescript.Cmd("delete", applescript.ID("virtual machine", id)),
 	)
 	return err
 }
 
-func DeleteVmByName(name string) error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`delete virtual machine named "%s"`, name),
+func (c *Client) DeleteVmByName(name string) error {
+	_, err := c.runUtmScript(
+		applescript.Cmd("delete", applescript.Named("virtual machine", name)),
 	)
 	return err
 }
 
-func CopyToVM(vm *VM, src, dst string) error {
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		fmt.Sprintf(`set input to POSIX file "%s"`, src),
-		fmt.Sprintf(`push of (open file of vm at "%s" for writing) from input`, dst),
+func (c *Client) CopyToVM(vm *VM, src, dst string) error {
+	input := applescript.Ident("input")
+	open := applescript.Cmd("open file of", vmVar,
+		applescript.Param{Label: "at", Value: applescript.String(dst)},
+		applescript.Param{Label: "for", Value: applescript.Ident("writing")},
+	)
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Set(input, applescript.PosixFile(src)),
+		applescript.Cmd("push of", applescript.Paren(open), applescript.Param{Label: "from", Value: input}),
 	)
 	return err
 }
 
-func RunCommandOnVM(vm *VM, cmd string, args ...string) error {
-	argStr := "{"
-	for _, arg := range args {
-		argStr += fmt.Sprintf("\"%s\", ", arg)
-	}
-	argStr = strings.TrimSuffix(argStr, ", ")
-	argStr += "}"
-	_, err := runUtmScript(
-		fmt.Sprintf(`set vm to virtual machine id "%s"`, vm.ID),
-		fmt.Sprintf(`execute of vm at "%s" with arguments %s`, cmd, argStr),
+// CopyFromVM pulls a guest file to a host path accessible to UTM.
+func (c *Client) CopyFromVM(vm *VM, src, dst string) error {
+	f, err := c.OpenGuestFile(vm, src, GuestFileModeReading)
+	if err != nil {
+		return err
+	}
+	if err := f.Pull(dst); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *Client) RunCommandOnVM(vm *VM, cmd string, args ...string) error {
+	_, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Cmd("execute of", vmVar,
+			applescript.Param{Label: "at", Value: applescript.String(cmd)},
+			applescript.Param{Label: "with arguments", Value: applescript.Strings(args...)},
+		),
 	)
 	return err
 }
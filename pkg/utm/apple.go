@@ -0,0 +1,91 @@
+package utm
+
+import (
+	"docker-machine-driver-utm/pkg/applescript"
+	"fmt"
+)
+
+func CreateAppleVM(conf *AppleConf) (*VM, error) {
+	return DefaultClient.CreateAppleVM(conf)
+}
+
+func UpdateAppleVM(vm *VM, conf *AppleConf) error {
+	return vm.utm().UpdateAppleVM(vm, conf)
+}
+
+func (vm *VM) GetAppleConfiguration() (*AppleConf, error) {
+	return vm.utm().GetAppleConfiguration(vm)
+}
+
+func (c *Client) CreateAppleVM(conf *AppleConf) (*VM, error) {
+	script := appleSources(conf)
+	create, err := makeVM(VmBackendApple, conf)
+	if err != nil {
+		return nil, err
+	}
+	output, err := c.runUtmScript(append(script, create, applescript.Return(vmRecord))...)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := &VM{}
+	if err := applescript.Unmarshal([]byte(output), vm); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return c.bind(vm), nil
+}
+
+func (c *Client) GetAppleConfiguration(vm *VM) (*AppleConf, error) {
+	if vm.Backend != "" && vm.Backend != VmBackendApple {
+		return nil, fmt.Errorf("unsupported backend: %s", vm.Backend)
+	}
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Return(applescript.Of("configuration", vmVar)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conf := &AppleConf{}
+	if err := applescript.Unmarshal([]byte(res), conf); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return conf, nil
+}
+
+// UpdateAppleVM merges conf into the current configuration of a stopped VM
+// using the same rules as UpdateQemuVM.
+func (c *Client) UpdateAppleVM(vm *VM, conf *AppleConf) error {
+	current, err := c.GetAppleConfiguration(vm)
+	if err != nil {
+		return err
+	}
+	merged := mergeAppleConf(current, conf)
+	script := appleSources(merged)
+
+	update, err := updateVM(merged)
+	if err != nil {
+		return err
+	}
+	_, err = c.runUtmScript(append(script, setVM(vm.ID), update)...)
+	return err
+}
+
+func appleSources(conf *AppleConf) []applescript.Stmt {
+	script := []applescript.Stmt{}
+	for i, drive := range conf.Drives {
+		if drive.Source != "" {
+			name := fmt.Sprintf("drive%d", i)
+			script = append(script, applescript.Set(applescript.Ident(name), applescript.PosixFile(string(drive.Source))))
+			conf.Drives[i].Source = AppleDriveSource(name)
+		}
+	}
+	for i, share := range conf.DirectoryShares {
+		if share.Source != "" {
+			name := fmt.Sprintf("share%d", i)
+			script = append(script, applescript.Set(applescript.Ident(name), applescript.PosixFile(string(share.Source))))
+			conf.DirectoryShares[i].Source = AppleDirectoryShareSource(name)
+		}
+	}
+	return script
+}
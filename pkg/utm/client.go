@@ -0,0 +1,37 @@
+package utm
+
+import "docker-machine-driver-utm/pkg/applescript"
+
+// Client runs UTM actions through a Runner. The package-level functions use
+// DefaultClient, which talks to the local UTM.app.
+type Client struct {
+	runner Runner
+}
+
+var DefaultClient = NewClient(OsascriptRunner{})
+
+func NewClient(runner Runner) *Client {
+	return &Client{runner: runner}
+}
+
+func (c *Client) runUtmScript(script ...applescript.Stmt) (string, error) {
+	res, err := c.runner.Run(applescript.Lines(script...)...)
+	if err != nil {
+		return "", parseScriptError(err)
+	}
+	return res, nil
+}
+
+func (c *Client) bind(vm *VM) *VM {
+	if vm != nil {
+		vm.client = c
+	}
+	return vm
+}
+
+func (vm *VM) utm() *Client {
+	if vm.client != nil {
+		return vm.client
+	}
+	return DefaultClient
+}
@@ -0,0 +1,100 @@
+package utm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientRunner(t *testing.T) {
+	var scripts [][]string
+	client := NewClient(RunnerFunc(func(script ...string) (string, error) {
+		scripts = append(scripts, script)
+		if strings.Contains(strings.Join(script, "\n"), "repeat with vm in virtual machines") {
+			return `{{id:"abc", name:"docker-machine-test", backend:qemu, status:stopped}}`, nil
+		}
+		return "", nil
+	}))
+
+	vm, err := client.GetVmByName("docker-machine-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.ID != "abc" || vm.Status != VmStatusStopped {
+		t.Fatalf("unexpected vm: %+v", vm)
+	}
+
+	if err := vm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) != 2 {
+		t.Fatalf("expected 2 scripts, got %d", len(scripts))
+	}
+	got := strings.Join(scripts[1], "\n")
+	want := "set vm to virtual machine id \"abc\"\nstart vm"
+	if got != want {
+		t.Fatalf("unexpected script:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := client.GetVmByName("missing"); err == nil {
+		t.Fatal("expected error for missing vm")
+	}
+}
+
+func TestClientGetConfiguration(t *testing.T) {
+	client := NewClient(RunnerFunc(func(script ...string) (string, error) {
+		return `{name:"docker-machine-test", notes:"", architecture:"x86_64", machine:"pc", memory:2048, cpu cores:2, ` +
+			`hypervisor:false, uefi:false, directory share mode:none, ` +
+			`drives:{{id:"6D3E", removable:true, interface:IDE, host size:0, guest size:0, raw:false}, ` +
+			`{id:"1F2A", removable:false, interface:IDE, host size:64, guest size:8192, raw:true}}, ` +
+			`network interfaces:{{index:0, hardware:"e1000", mode:shared, address:"52:54:00:12:34:56", host interface:"", port forwarding:{}}}, ` +
+			`serial ports:{}, displays:{}}`, nil
+	}))
+
+	conf, err := client.GetConfiguration(&VM{ID: "abc", Backend: VmBackendQemu})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Memory != 2048 || conf.CPU != 2 || conf.DirectoryShare != DirectoryShareModeNone {
+		t.Fatalf("unexpected configuration: %+v", conf)
+	}
+	if len(conf.Drives) != 2 || conf.Drives[1].ID != "1F2A" || !conf.Drives[1].Raw || conf.Drives[1].GuestSize != 8192 {
+		t.Fatalf("unexpected drives: %+v", conf.Drives)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].Mode != QemuNetworkModeShared || conf.Networks[0].MAC != "52:54:00:12:34:56" {
+		t.Fatalf("unexpected networks: %+v", conf.Networks)
+	}
+}
+
+func TestClientQuoting(t *testing.T) {
+	var scripts []string
+	client := NewClient(RunnerFunc(func(script ...string) (string, error) {
+		scripts = append(scripts, strings.Join(script, "\n"))
+		return "", nil
+	}))
+
+	vm := client.bind(&VM{ID: `abc" & (do shell script "id") & "`})
+	if err := client.RunCommandOnVM(vm, "/bin/echo", `it's "quoted"`, `back\slash`); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteVmByName("docker-machine-\"test\""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.OpenGuestFile(vm, "/etc/hosts", `reading) & (do shell script "id"`); err == nil {
+		t.Fatal("expected an invalid guest file mode to be rejected")
+	}
+
+	want := []string{
+		`set vm to virtual machine id "abc\" & (do shell script \"id\") & \""` + "\n" +
+			`execute of vm at "/bin/echo" with arguments {"it's \"quoted\"", "back\\slash"}`,
+		`delete virtual machine named "docker-machine-\"test\""`,
+	}
+	if len(scripts) != len(want) {
+		t.Fatalf("expected %d scripts, got %d", len(want), len(scripts))
+	}
+	for i := range want {
+		if scripts[i] != want[i] {
+			t.Errorf("unexpected script:\n%s\nwant:\n%s", scripts[i], want[i])
+		}
+	}
+}
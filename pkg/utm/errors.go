@@ -0,0 +1,82 @@
+package utm
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrVMNotFound            = errors.New("vm not found")
+	ErrUTMNotRunning         = errors.New("UTM is not running")
+	ErrAutomationDenied      = errors.New("not authorized to control UTM, allow it in System Settings > Privacy & Security > Automation")
+	ErrGuestAgentUnavailable = errors.New("guest agent is not available")
+	ErrInvalidState          = errors.New("operation not available in the current VM state")
+)
+
+// AppleScript error numbers reported by osascript.
+const (
+	errNoSuchObject      = -1728
+	errEventNotPermitted = -1743
+	errProcessNotFound   = -600
+	errConnectionInvalid = -609
+)
+
+// ScriptError is an error reported by AppleScript while running a script.
+// It matches one of the Err* sentinels with errors.Is when the error number
+// or message is recognized.
+type ScriptError struct {
+	Number  int
+	Message string
+
+	kind error
+	raw  error
+}
+
+func (e *ScriptError) Error() string {
+	if e.kind != nil {
+		return e.kind.Error() + ": " + e.Message
+	}
+	return e.raw.Error()
+}
+
+func (e *ScriptError) Unwrap() []error {
+	if e.kind != nil {
+		return []error{e.kind, e.raw}
+	}
+	return []error{e.raw}
+}
+
+var reExecutionError = regexp.MustCompile(`execution error: (.*?) \((-?\d+)\)`)
+
+// parseScriptError turns the output of a failed osascript run into a
+// ScriptError. Errors that do not come from AppleScript are returned as is.
+func parseScriptError(err error) error {
+	var se *ScriptError
+	if err == nil || errors.As(err, &se) {
+		return err
+	}
+
+	m := reExecutionError.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	number, _ := strconv.Atoi(m[2])
+	se = &ScriptError{Number: number, Message: m[1], raw: err}
+
+	message := strings.ToLower(m[1])
+	switch {
+	case number == errEventNotPermitted:
+		se.kind = ErrAutomationDenied
+	case number == errProcessNotFound || number == errConnectionInvalid:
+		se.kind = ErrUTMNotRunning
+	case number == errNoSuchObject && strings.Contains(message, "virtual machine"):
+		se.kind = ErrVMNotFound
+	case strings.Contains(message, "guest agent"):
+		se.kind = ErrGuestAgentUnavailable
+	case strings.Contains(message, "operation not available"), strings.Contains(message, "is not running"):
+		se.kind = ErrInvalidState
+	}
+	return se
+}
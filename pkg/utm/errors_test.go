@@ -0,0 +1,42 @@
+package utm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseScriptError(t *testing.T) {
+	tests := []struct {
+		output string
+		number int
+		want   error
+	}{
+		{`0:52: execution error: UTM got an error: Can’t get virtual machine id "abc". (-1728)`, -1728, ErrVMNotFound},
+		{`0:36: execution error: Not authorized to send Apple events to UTM. (-1743)`, -1743, ErrAutomationDenied},
+		{`0:12: execution error: UTM got an error: Application isn’t running. (-600)`, -600, ErrUTMNotRunning},
+		{`execution error: UTM got an error: The QEMU guest agent is not running or not installed on the guest. (-2700)`, -2700, ErrGuestAgentUnavailable},
+		{`execution error: UTM got an error: Operation not available. (-2700)`, -2700, ErrInvalidState},
+		{`execution error: UTM got an error: Virtual machine is not running. (-2700)`, -2700, ErrInvalidState},
+		{`execution error: UTM got an error: Can’t get drive id "x". (-1728)`, -1728, nil},
+	}
+	for _, tt := range tests {
+		err := parseScriptError(errors.New("exit status 1: " + tt.output + " (tell application \"UTM\"...)"))
+		var se *ScriptError
+		if !errors.As(err, &se) {
+			t.Fatalf("%s: expected a ScriptError, got %T", tt.output, err)
+		}
+		if se.Number != tt.number {
+			t.Errorf("%s: got number %d, want %d", tt.output, se.Number, tt.number)
+		}
+		for _, sentinel := range []error{ErrVMNotFound, ErrUTMNotRunning, ErrAutomationDenied, ErrGuestAgentUnavailable, ErrInvalidState} {
+			if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+				t.Errorf("%s: errors.Is(%v) = %t", tt.output, sentinel, got)
+			}
+		}
+	}
+
+	plain := errors.New("exec: \"osascript\": executable file not found in $PATH")
+	if err := parseScriptError(plain); err != plain {
+		t.Fatalf("expected non-script error to pass through, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package utm
+
+import (
+	"context"
+	"docker-machine-driver-utm/pkg/applescript"
+	"encoding/base64"
+	"fmt"
+)
+
+// ExecOptions tunes a command run with Exec.
+type ExecOptions struct {
+	// Env holds extra environment variables in NAME=VALUE form.
+	Env []string
+	// Stdin is fed to the standard input of the command.
+	Stdin []byte
+}
+
+// ExecResult is the outcome of a command run with Exec.
+type ExecResult struct {
+	ExitCode int
+	// Signal is the signal that terminated the command, or 0.
+	Signal int
+	Stdout []byte
+	Stderr []byte
+}
+
+// processResult is the `execute result` record returned by `get result`.
+type processResult struct {
+	Exited       bool   `applescript:"exited"`
+	ExitCode     int    `applescript:"exit code"`
+	SignalCode   int    `applescript:"signal code"`
+	OutputText   string `applescript:"output text"`
+	OutputBase64 string `applescript:"output base64"`
+	ErrorText    string `applescript:"error text"`
+	ErrorBase64  string `applescript:"error base64"`
+}
+
+func Exec(ctx context.Context, vm *VM, cmd string, args []string, opts *ExecOptions) (*ExecResult, error) {
+	return vm.utm().Exec(ctx, vm, cmd, args, opts)
+}
+
+// Exec runs cmd in the guest through the guest agent and waits for it to
+// exit, polling its result with exponential backoff. The command keeps running
+// in the guest when ctx is done first.
+func (c *Client) Exec(ctx context.Context, vm *VM, cmd string, args []string, opts *ExecOptions) (*ExecResult, error) {
+	if opts == nil {
+		opts = &ExecOptions{}
+	}
+	pid, err := c.startProcess(vm, cmd, args, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *processResult
+	var lastErr error
+	err = poll(ctx, func() bool {
+		res, lastErr = c.processResult(vm, pid)
+		return lastErr != nil || res.Exited
+	})
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("waiting for %s to exit: %w", cmd, err)
+	}
+
+	result := &ExecResult{ExitCode: res.ExitCode, Signal: res.SignalCode}
+	if result.Stdout, err = processOutput(res.OutputText, res.OutputBase64); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if result.Stderr, err = processOutput(res.ErrorText, res.ErrorBase64); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Client) startProcess(vm *VM, cmd string, args []string, opts *ExecOptions) (int, error) {
+	proc := applescript.Ident("proc")
+	params := []applescript.Param{
+		{Label: "at", Value: applescript.String(cmd)},
+		{Label: "with arguments", Value: applescript.Strings(args...)},
+	}
+	if len(opts.Env) > 0 {
+		params = append(params, applescript.Param{Label: "with environment", Value: applescript.Strings(opts.Env...)})
+	}
+	if opts.Stdin != nil {
+		params = append(params,
+			applescript.Param{Label: "using input", Value: applescript.String(base64.StdEncoding.EncodeToString(opts.Stdin))},
+			applescript.Param{Label: "base64 encoding"},
+		)
+	}
+	params = append(params, applescript.Param{Label: "output capturing"})
+
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Set(proc, applescript.Cmd("execute of", vmVar, params...)),
+		applescript.Return(applescript.Of("id", proc)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var pid int
+	if err := applescript.Unmarshal([]byte(res), &pid); err != nil {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+	return pid, nil
+}
+
+func (c *Client) processResult(vm *VM, pid int) (*processResult, error) {
+	process := applescript.Elem(applescript.IDOf("guest process", applescript.Int(pid)), vmVar)
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Return(applescript.Cmd("get result of", applescript.Paren(process))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	result := &processResult{}
+	if err := applescript.Unmarshal([]byte(res), result); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return result, nil
+}
+
+// processOutput prefers the base64 form of captured output, which survives
+// output that is not valid UTF-8.
+func processOutput(text, encoded string) ([]byte, error) {
+	if encoded == "" {
+		return []byte(text), nil
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
@@ -0,0 +1,208 @@
+package utm
+
+import (
+	"docker-machine-driver-utm/pkg/applescript"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+type GuestFileMode string
+
+const (
+	GuestFileModeReading   GuestFileMode = "reading"
+	GuestFileModeWriting   GuestFileMode = "writing"
+	GuestFileModeAppending GuestFileMode = "appending"
+)
+
+// guestFileChunk bounds the data moved by a single read or write, which is
+// sent base64 encoded through the guest agent.
+const guestFileChunk = 1 << 20
+
+// GuestFile is a file opened in the guest through the guest agent. Data is
+// streamed in chunks, so it works with files of any size. It must be closed
+// to release the handle in the guest.
+type GuestFile struct {
+	client *Client
+	vm     *VM
+	id     int
+	path   string
+	closed bool
+}
+
+func OpenGuestFile(vm *VM, path string, mode GuestFileMode) (*GuestFile, error) {
+	return vm.utm().OpenGuestFile(vm, path, mode)
+}
+
+// OpenGuestFile opens a file in the guest. Writing truncates the file and
+// appending writes at its end; both create it when missing.
+func (c *Client) OpenGuestFile(vm *VM, path string, mode GuestFileMode) (*GuestFile, error) {
+	switch mode {
+	case GuestFileModeReading, GuestFileModeWriting, GuestFileModeAppending:
+	default:
+		return nil, fmt.Errorf("invalid guest file mode %q", mode)
+	}
+	file := applescript.Ident("f")
+	res, err := c.runUtmScript(
+		setVM(vm.ID),
+		applescript.Set(file, applescript.Cmd("open file of", vmVar,
+			applescript.Param{Label: "at", Value: applescript.String(path)},
+			applescript.Param{Label: "for", Value: applescript.Ident(mode)},
+		)),
+		applescript.Return(applescript.Of("id", file)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var id int
+	if err := applescript.Unmarshal([]byte(res), &id); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &GuestFile{client: c, vm: vm, id: id, path: path}, nil
+}
+
+// Name returns the guest path of the file.
+func (f *GuestFile) Name() string {
+	return f.path
+}
+
+// Read reads up to len(p) bytes from the current offset. It returns io.EOF at
+// the end of the file.
+func (f *GuestFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fmt.Errorf("read %s: file already closed", f.path)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size := min(len(p), guestFileChunk)
+	res, err := f.run(applescript.Return(applescript.Cmd("read of", f.ref(),
+		applescript.Param{Label: "for length", Value: applescript.Int(size)},
+		applescript.Param{Label: "base64 encoding"},
+	)))
+	if err != nil {
+		return 0, err
+	}
+	var encoded string
+	if err := applescript.Unmarshal([]byte(res), &encoded); err != nil {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+	if len(data) == 0 {
+		return 0, io.EOF
+	}
+	return copy(p, data), nil
+}
+
+// Write writes p at the current offset, in chunks.
+func (f *GuestFile) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, fmt.Errorf("write %s: file already closed", f.path)
+	}
+	n := 0
+	for n < len(p) {
+		chunk := p[n:min(len(p), n+guestFileChunk)]
+		_, err := f.run(applescript.Cmd("write of", f.ref(),
+			applescript.Param{Label: "with data", Value: applescript.String(base64.StdEncoding.EncodeToString(chunk))},
+			applescript.Param{Label: "base64 encoding"},
+		))
+		if err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// ReadFrom writes the data read from r until EOF, in chunks of the largest
+// size a single write accepts. It lets io.Copy stream into the file with few
+// round trips to UTM.
+func (f *GuestFile) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, guestFileChunk)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			written, werr := f.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteTo writes the rest of the file to w, in chunks of the largest size a
+// single read returns.
+func (f *GuestFile) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, guestFileChunk)
+	var total int64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			written, werr := w.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Pull copies the rest of the file to a host path in one operation and
+// closes it. The host path must be accessible to UTM.
+func (f *GuestFile) Pull(dst string) error {
+	return f.transfer("pull", applescript.Param{Label: "to", Value: applescript.PosixFile(dst)})
+}
+
+// Push replaces the contents of the file with a host file in one operation
+// and closes it. The host path must be accessible to UTM.
+func (f *GuestFile) Push(src string) error {
+	return f.transfer("push", applescript.Param{Label: "from", Value: applescript.PosixFile(src)})
+}
+
+func (f *GuestFile) transfer(verb string, param applescript.Param) error {
+	if f.closed {
+		return fmt.Errorf("%s %s: file already closed", verb, f.path)
+	}
+	_, err := f.run(applescript.Cmd(verb+" of", f.ref(), param))
+	if err == nil {
+		f.closed = true
+	}
+	return err
+}
+
+// Close releases the file in the guest. Closing a file twice is a no-op.
+func (f *GuestFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	if _, err := f.run(applescript.Cmd("close of", f.ref())); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *GuestFile) ref() applescript.Expr {
+	return applescript.Paren(applescript.Elem(applescript.IDOf("guest file", applescript.Int(f.id)), vmVar))
+}
+
+func (f *GuestFile) run(stmt applescript.Stmt) (string, error) {
+	return f.client.runUtmScript(setVM(f.vm.ID), stmt)
+}
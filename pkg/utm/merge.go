@@ -0,0 +1,120 @@
+package utm
+
+import "reflect"
+
+func mergeQemuConf(current, update *QemuConf) *QemuConf {
+	merged := *current
+	overlay(&merged, update)
+
+	merged.Drives = append([]QemuDriveConf(nil), current.Drives...)
+	for _, drive := range update.Drives {
+		found := false
+		for i := range merged.Drives {
+			if drive.ID != "" && merged.Drives[i].ID == drive.ID {
+				overlay(&merged.Drives[i], &drive)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Drives = append(merged.Drives, drive)
+		}
+	}
+
+	merged.Networks = append([]QemuNetworkConf(nil), current.Networks...)
+	for _, network := range update.Networks {
+		found := false
+		for i := range merged.Networks {
+			if merged.Networks[i].Index == network.Index {
+				if network.Mode != "" && network.Mode != merged.Networks[i].Mode {
+					// Only the identity of the interface survives a change of
+					// mode: the host interface and forwards of the old mode
+					// no longer apply.
+					current := merged.Networks[i]
+					merged.Networks[i] = QemuNetworkConf{Index: current.Index, Hardware: current.Hardware, MAC: current.MAC}
+				}
+				overlay(&merged.Networks[i], &network)
+				if len(network.PortForwarding) > 0 {
+					merged.Networks[i].PortForwarding = network.PortForwarding
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Networks = append(merged.Networks, network)
+		}
+	}
+
+	return &merged
+}
+
+// overlay copies every non-zero scalar field of src onto dst. Slices are left
+// to the caller, which knows how their elements are matched.
+func overlay[T any](dst, src *T) {
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	for i := 0; i < s.NumField(); i++ {
+		field := s.Field(i)
+		if field.Kind() == reflect.Slice || field.IsZero() || !d.Field(i).CanSet() {
+			continue
+		}
+		d.Field(i).Set(field)
+	}
+}
+
+func mergeAppleConf(current, update *AppleConf) *AppleConf {
+	merged := *current
+	overlay(&merged, update)
+
+	merged.Drives = append([]AppleDriveConf(nil), current.Drives...)
+	for _, drive := range update.Drives {
+		found := false
+		for i := range merged.Drives {
+			if drive.ID != "" && merged.Drives[i].ID == drive.ID {
+				overlay(&merged.Drives[i], &drive)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Drives = append(merged.Drives, drive)
+		}
+	}
+
+	merged.DirectoryShares = append([]AppleDirectoryShareConf(nil), current.DirectoryShares...)
+	for _, share := range update.DirectoryShares {
+		found := false
+		for i := range merged.DirectoryShares {
+			if share.ID != "" && merged.DirectoryShares[i].ID == share.ID {
+				overlay(&merged.DirectoryShares[i], &share)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.DirectoryShares = append(merged.DirectoryShares, share)
+		}
+	}
+
+	merged.Networks = append([]AppleNetworkConf(nil), current.Networks...)
+	for _, network := range update.Networks {
+		found := false
+		for i := range merged.Networks {
+			if merged.Networks[i].Index == network.Index {
+				if network.Mode != "" && network.Mode != merged.Networks[i].Mode {
+					current := merged.Networks[i]
+					merged.Networks[i] = AppleNetworkConf{Index: current.Index, MAC: current.MAC}
+				}
+				overlay(&merged.Networks[i], &network)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Networks = append(merged.Networks, network)
+		}
+	}
+
+	return &merged
+}
@@ -7,6 +7,9 @@ type QemuDriveInterface string
 type QemuDriveSource string
 type QemuNetworkMode string
 type QemuPortForwardingProtocol string
+type AppleDriveSource string
+type AppleNetworkMode string
+type AppleDirectoryShareSource string
 
 const (
 	VmBackendApple      VmBackend = "apple"
@@ -50,6 +53,11 @@ const (
 	QemuNetworkModeBridged  QemuNetworkMode = "bridged"
 )
 
+const (
+	AppleNetworkModeShared  AppleNetworkMode = "shared"
+	AppleNetworkModeBridged AppleNetworkMode = "bridged"
+)
+
 const (
 	QemuPortForwardingProtocolTCP QemuPortForwardingProtocol = "TCP"
 	QemuPortForwardingProtocolUDP QemuPortForwardingProtocol = "UDP"
@@ -60,6 +68,8 @@ type VM struct {
 	Name    string    `applescript:"name"`
 	Backend VmBackend `applescript:"backend"`
 	Status  VmStatus  `applescript:"status"`
+
+	client *Client `applescript:"-"`
 }
 
 type QemuConf struct {
@@ -85,12 +95,12 @@ type QemuDriveConf struct {
 }
 
 type QemuNetworkConf struct {
-	Index          int                      `applescript:"index"`
+	Index          int                      `applescript:"index,keepempty"`
 	Hardware       string                   `applescript:"hardware"`
 	Mode           QemuNetworkMode          `applescript:"mode"`
 	MAC            string                   `applescript:"address"`
-	HostInterface  string                   `applescript:"host interface"`
-	PortForwarding []QemuPortForwardingConf `applescript:"port forwarding"`
+	HostInterface  string                   `applescript:"host interface,keepempty"`
+	PortForwarding []QemuPortForwardingConf `applescript:"port forwarding,keepempty"`
 }
 
 type QemuPortForwardingConf struct {
@@ -100,3 +110,35 @@ type QemuPortForwardingConf struct {
 	GuestAddr string                     `applescript:"guest address"`
 	GuestPort int                        `applescript:"guest port"`
 }
+
+type AppleConf struct {
+	Name            string                    `applescript:"name"`
+	Notes           string                    `applescript:"notes"`
+	Memory          int                       `applescript:"memory"`
+	CPU             int                       `applescript:"cpu cores"`
+	Rosetta         bool                      `applescript:"rosetta"`
+	DirectoryShares []AppleDirectoryShareConf `applescript:"directory shares"`
+	Drives          []AppleDriveConf          `applescript:"drives"`
+	Networks        []AppleNetworkConf        `applescript:"network interfaces"`
+}
+
+type AppleDirectoryShareConf struct {
+	ID       string                    `applescript:"id"`
+	ReadOnly bool                      `applescript:"read only"`
+	Source   AppleDirectoryShareSource `applescript:"source"`
+}
+
+type AppleDriveConf struct {
+	ID        string           `applescript:"id"`
+	Removable bool             `applescript:"removable"`
+	HostSize  int              `applescript:"host size"`
+	GuestSize int              `applescript:"guest size"`
+	Source    AppleDriveSource `applescript:"source"`
+}
+
+type AppleNetworkConf struct {
+	Index         int              `applescript:"index,keepempty"`
+	Mode          AppleNetworkMode `applescript:"mode"`
+	MAC           string           `applescript:"address"`
+	HostInterface string           `applescript:"host interface,keepempty"`
+}
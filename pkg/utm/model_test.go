@@ -2,6 +2,7 @@ package utm
 
 import (
 	"docker-machine-driver-utm/pkg/applescript"
+	"reflect"
 	"testing"
 )
 
@@ -25,7 +26,8 @@ func TestMarshal(t *testing.T) {
 		Drives: []QemuDriveConf{
 			{
 				Removable: true,
-				Source:    "/tmp/boot2docker.iso",
+				// Sources name the file variables driveSources binds.
+				Source: "drive0",
 			},
 			{
 				GuestSize: 8192,
@@ -44,3 +46,116 @@ func TestMarshal(t *testing.T) {
 	}
 	t.Log(string(res))
 }
+
+func TestMarshalInvalidEnum(t *testing.T) {
+	conf := &QemuConf{
+		Name:     "boot2docker",
+		Networks: []QemuNetworkConf{{Mode: `shared}, name:(do shell script "id"), x:{`}},
+	}
+	if _, err := applescript.Marshal(conf); err == nil {
+		t.Fatal("expected an invalid network mode to be rejected")
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	conf := &QemuConf{
+		Name:         "boot2docker",
+		Architecture: "x86_64",
+		Memory:       1024,
+		CPU:          1,
+		Drives: []QemuDriveConf{
+			{
+				Removable: true,
+				Interface: QemuDriveInterfaceIDE,
+			},
+			{
+				GuestSize: 8192,
+			},
+		},
+		Networks: []QemuNetworkConf{
+			{
+				Mode: QemuNetworkModeShared,
+				PortForwarding: []QemuPortForwardingConf{
+					{Protocol: QemuPortForwardingProtocolTCP, HostPort: 2222, GuestPort: 22},
+				},
+			},
+		},
+	}
+
+	res, err := applescript.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &QemuConf{}
+	if err := applescript.Unmarshal(res, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf, decoded) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", conf, decoded)
+	}
+}
+
+func TestMergeQemuConf(t *testing.T) {
+	current := &QemuConf{
+		Name:   "boot2docker",
+		Memory: 1024,
+		CPU:    1,
+		Drives: []QemuDriveConf{
+			{ID: "A", Removable: true, Interface: QemuDriveInterfaceIDE},
+			{ID: "B", Interface: QemuDriveInterfaceIDE, GuestSize: 8192},
+		},
+		Networks: []QemuNetworkConf{
+			{Index: 0, Mode: QemuNetworkModeShared, MAC: "52:54:00:00:00:01"},
+		},
+	}
+	update := &QemuConf{
+		Memory: 2048,
+		Drives: []QemuDriveConf{
+			{ID: "B", Interface: QemuDriveInterfaceVirtIO},
+			{GuestSize: 1024},
+		},
+		Networks: []QemuNetworkConf{
+			{Index: 0, Mode: QemuNetworkModeBridged, HostInterface: "en0"},
+			{Index: 1, Mode: QemuNetworkModeHost},
+		},
+	}
+
+	merged := mergeQemuConf(current, update)
+	if merged.Name != "boot2docker" || merged.Memory != 2048 || merged.CPU != 1 {
+		t.Fatalf("unexpected merge: %+v", merged)
+	}
+	if len(merged.Drives) != 3 || merged.Drives[1].Interface != QemuDriveInterfaceVirtIO || merged.Drives[1].GuestSize != 8192 {
+		t.Fatalf("unexpected drives: %+v", merged.Drives)
+	}
+	if len(merged.Networks) != 2 || merged.Networks[0].MAC != "52:54:00:00:00:01" || merged.Networks[0].HostInterface != "en0" {
+		t.Fatalf("unexpected networks: %+v", merged.Networks)
+	}
+	if current.Memory != 1024 || current.Drives[1].Interface != QemuDriveInterfaceIDE {
+		t.Fatalf("current configuration was modified: %+v", current)
+	}
+}
+
+func TestMergeQemuConfModeChange(t *testing.T) {
+	current := &QemuConf{
+		Networks: []QemuNetworkConf{
+			{Index: 0, Mode: QemuNetworkModeBridged, Hardware: "virtio-net-pci", MAC: "52:54:00:00:00:01", HostInterface: "en0"},
+			{Index: 1, Mode: QemuNetworkModeEmulated, PortForwarding: []QemuPortForwardingConf{{Protocol: QemuPortForwardingProtocolTCP, HostPort: 2222, GuestPort: 22}}},
+		},
+	}
+	update := &QemuConf{
+		Networks: []QemuNetworkConf{
+			{Index: 0, Mode: QemuNetworkModeShared},
+			{Index: 1, Mode: QemuNetworkModeHost},
+		},
+	}
+
+	merged := mergeQemuConf(current, update)
+	want := []QemuNetworkConf{
+		{Index: 0, Mode: QemuNetworkModeShared, Hardware: "virtio-net-pci", MAC: "52:54:00:00:00:01"},
+		{Index: 1, Mode: QemuNetworkModeHost},
+	}
+	if !reflect.DeepEqual(merged.Networks, want) {
+		t.Fatalf("unexpected networks: %+v", merged.Networks)
+	}
+}
@@ -1,9 +1,46 @@
 package utm
 
-import "github.com/andybrewer/mack"
+import (
+	"bytes"
+	"docker-machine-driver-utm/pkg/applescript"
+	"errors"
+	"os/exec"
+	"strings"
+)
 
 const UtmAppName = "UTM"
 
-func runUtmScript(script ...string) (string, error) {
-	return mack.Tell(UtmAppName, script...)
+// Runner executes AppleScript statements inside a tell block addressed to UTM
+// and returns the result of the script in AppleScript source form.
+type Runner interface {
+	Run(script ...string) (string, error)
+}
+
+// RunnerFunc adapts an ordinary function to the Runner interface.
+type RunnerFunc func(script ...string) (string, error)
+
+func (f RunnerFunc) Run(script ...string) (string, error) {
+	return f(script...)
+}
+
+// OsascriptRunner talks to the local UTM.app through osascript. Results are
+// printed in source form so they can be decoded with applescript.Unmarshal.
+type OsascriptRunner struct{}
+
+func (OsascriptRunner) Run(script ...string) (string, error) {
+	command := buildTell(UtmAppName, script...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("osascript", "-s", "s", "-e", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(err.Error() + ": " + strings.TrimSpace(stderr.String()) + " (" + command + ")")
+	}
+
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+func buildTell(application string, script ...string) string {
+	return applescript.Render(applescript.Tell(application, applescript.Source(script...)))
 }
@@ -0,0 +1,117 @@
+package utm
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestScripts compares the script of every action, as osascript receives it,
+// with testdata/<action>.golden. Run with -update after changing a script.
+func TestScripts(t *testing.T) {
+	qemuConf := func() *QemuConf {
+		return &QemuConf{
+			Name:         `docker-machine-"test"`,
+			Architecture: "x86_64",
+			Memory:       1024,
+			CPU:          1,
+			Drives: []QemuDriveConf{
+				{Removable: true, Source: "/tmp/boot2docker.iso"},
+				{Raw: true, Interface: QemuDriveInterfaceIDE, Source: "/tmp/disk.img"},
+			},
+			Networks: []QemuNetworkConf{{Mode: QemuNetworkModeShared}},
+		}
+	}
+	appleConf := func() *AppleConf {
+		return &AppleConf{
+			Name:            "docker-machine-apple",
+			Memory:          1024,
+			CPU:             1,
+			Drives:          []AppleDriveConf{{Source: "/tmp/disk.img"}},
+			DirectoryShares: []AppleDirectoryShareConf{{Source: "/Users"}},
+			Networks:        []AppleNetworkConf{{Mode: AppleNetworkModeShared}},
+		}
+	}
+	vm := &VM{ID: "00000000-0000-0000-0000-000000000001", Backend: VmBackendQemu}
+	appleVM := &VM{ID: "00000000-0000-0000-0000-000000000002", Backend: VmBackendApple}
+
+	tests := []struct {
+		name string
+		run  func(c *Client) error
+	}{
+		{"version", func(c *Client) error { _, err := c.Version(); return err }},
+		{"list", func(c *Client) error { _, err := c.ListVMs(); return err }},
+		{"start", func(c *Client) error { return c.Start(vm) }},
+		{"start_disposable", func(c *Client) error { return c.StartDisposable(vm) }},
+		{"pause", func(c *Client) error { return c.Pause(vm) }},
+		{"pause_and_save", func(c *Client) error { return c.PauseAndSave(vm) }},
+		{"stop", func(c *Client) error { return c.Stop(vm) }},
+		{"request_stop", func(c *Client) error { return c.RequestStop(vm) }},
+		{"shutdown", func(c *Client) error { return c.Shutdown(vm) }},
+		{"kill", func(c *Client) error { return c.Kill(vm) }},
+		{"status", func(c *Client) error { _, err := c.GetStatus(vm); return err }},
+		{"ip", func(c *Client) error { _, err := c.GetIP(vm); return err }},
+		{"ips", func(c *Client) error { _, err := c.GetIPs(vm); return err }},
+		{"configuration", func(c *Client) error { _, err := c.GetConfiguration(vm); return err }},
+		{"create_qemu", func(c *Client) error { _, err := c.CreateQemuVM(qemuConf()); return err }},
+		{"update_qemu", func(c *Client) error { return c.UpdateQemuVM(vm, qemuConf()) }},
+		{"create_apple", func(c *Client) error { _, err := c.CreateAppleVM(appleConf()); return err }},
+		{"update_apple", func(c *Client) error { return c.UpdateAppleVM(appleVM, appleConf()) }},
+		{"delete_by_id", func(c *Client) error { return c.DeleteVmByID(vm.ID) }},
+		{"delete_by_name", func(c *Client) error { return c.DeleteVmByName(`docker-machine-"test"`) }},
+		{"copy_to_vm", func(c *Client) error { return c.CopyToVM(vm, "/tmp/host file", "/tmp/guest file") }},
+		{"run_command", func(c *Client) error { return c.RunCommandOnVM(vm, "/bin/sh", "-c", `echo "hello"`) }},
+		{"exec", func(c *Client) error {
+			_, err := c.startProcess(vm, "/bin/sh", []string{"-c", "cat"}, &ExecOptions{Env: []string{"LANG=C"}, Stdin: []byte("input\n")})
+			return err
+		}},
+		{"exec_result", func(c *Client) error { _, err := c.processResult(vm, 42); return err }},
+		{"open_file", func(c *Client) error {
+			_, err := c.OpenGuestFile(vm, "/var/log/docker.log", GuestFileModeReading)
+			return err
+		}},
+		{"read_file", func(c *Client) error {
+			f := &GuestFile{client: c, vm: vm, id: 7}
+			if _, err := f.Read(make([]byte, 4096)); err != io.EOF {
+				return err
+			}
+			return nil
+		}},
+		{"write_file", func(c *Client) error {
+			_, err := (&GuestFile{client: c, vm: vm, id: 7}).Write([]byte("hello\n"))
+			return err
+		}},
+		{"close_file", func(c *Client) error { return (&GuestFile{client: c, vm: vm, id: 7}).Close() }},
+		{"pull_file", func(c *Client) error { return (&GuestFile{client: c, vm: vm, id: 7}).Pull("/tmp/docker.log") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			client := NewClient(RunnerFunc(func(script ...string) (string, error) {
+				got = buildTell(UtmAppName, script...) + "\n"
+				return "missing value", nil
+			}))
+			if err := tt.run(client); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("script differs from %s:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,903 @@
+// Package utmtest provides an in-memory UTM backend that understands the
+// scripts emitted by pkg/utm, so clients can be exercised without UTM.app.
+package utmtest
+
+import (
+	"docker-machine-driver-utm/pkg/applescript"
+	"docker-machine-driver-utm/pkg/utm"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	errNotRunning   = -600
+	errNotFound     = -1728
+	errGeneral      = -2700
+	errNotSupported = -2753
+)
+
+type VM struct {
+	ID      string
+	Name    string
+	Backend utm.VmBackend
+	Status  utm.VmStatus
+	IPs     []string
+	Config  *utm.QemuConf
+
+	// AppleConfig holds the configuration of machines using the Apple
+	// backend, in which case Config is nil.
+	AppleConfig *utm.AppleConf
+
+	// GuestAgent reports whether the guest agent answers queries such as
+	// query ip. It defaults to true for machines created by the fake.
+	GuestAgent bool
+	// ACPI reports whether the guest powers off on a stop request. It
+	// defaults to true for machines created by the fake.
+	ACPI bool
+	// Executed lists the commands run through the guest agent.
+	Executed []string
+	// Files holds the guest files reached through the guest agent, by path.
+	Files map[string][]byte
+
+	next utm.VmStatus
+	at   time.Time
+}
+
+// Backend is a fake UTM that keeps VM records in memory. The delays control
+// how long a machine stays in a transitional status (starting, pausing,
+// stopping, resuming) before it settles.
+type Backend struct {
+	StartDelay  time.Duration
+	PauseDelay  time.Duration
+	StopDelay   time.Duration
+	ResumeDelay time.Duration
+
+	// Version is reported by the application's version property.
+	Version string
+
+	// Exec produces the result of guest commands that capture their output.
+	// When nil, commands exit with status 0 and print nothing. ExecDelay is
+	// how long a command runs before its result is available.
+	Exec      func(vm string, cmd Command) utm.ExecResult
+	ExecDelay time.Duration
+
+	mu        sync.Mutex
+	quit      bool
+	failing   map[string]bool
+	vms       []*VM
+	nextID    int
+	nextMAC   int
+	processes map[int]*process
+	files     map[int]*guestFile
+	scripts   [][]string
+}
+
+// Command is a command run through the guest agent.
+type Command struct {
+	Path  string
+	Args  []string
+	Env   []string
+	Stdin []byte
+}
+
+type guestFile struct {
+	vm     *VM
+	path   string
+	offset int
+	closed bool
+}
+
+type process struct {
+	vm     *VM
+	result utm.ExecResult
+	done   time.Time
+}
+
+func New() *Backend {
+	return &Backend{Version: "4.6.4"}
+}
+
+// Client returns a utm.Client that runs its scripts against the backend.
+func (b *Backend) Client() *utm.Client {
+	return utm.NewClient(b)
+}
+
+// AddVM registers an existing machine with the backend.
+func (b *Backend) AddVM(name string, status utm.VmStatus) *VM {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addVM(name, utm.VmBackendQemu, status, &utm.QemuConf{Name: name})
+}
+
+// VM returns a snapshot of the machine with the given name.
+func (b *Backend) VM(name string) (VM, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vm := range b.vms {
+		if vm.Name == name {
+			b.advance(vm)
+			return *vm, true
+		}
+	}
+	return VM{}, false
+}
+
+// VMs returns a snapshot of every machine known to the backend.
+func (b *Backend) VMs() []VM {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	vms := make([]VM, len(b.vms))
+	for i, vm := range b.vms {
+		b.advance(vm)
+		vms[i] = *vm
+	}
+	return vms
+}
+
+// SetGuestAgent toggles whether the named machine answers guest agent queries.
+func (b *Backend) SetGuestAgent(name string, enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vm := range b.vms {
+		if vm.Name == name {
+			vm.GuestAgent = enabled
+		}
+	}
+}
+
+// SetIPs replaces the addresses reported by query ip for the named machine.
+func (b *Backend) SetIPs(name string, ips ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vm := range b.vms {
+		if vm.Name == name {
+			vm.IPs = ips
+		}
+	}
+}
+
+// SetACPI toggles whether the named machine honors stop requests.
+func (b *Backend) SetACPI(name string, enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vm := range b.vms {
+		if vm.Name == name {
+			vm.ACPI = enabled
+		}
+	}
+}
+
+// Fail makes every future run of statement fail with a generic error, to
+// simulate UTM rejecting an operation.
+func (b *Backend) Fail(statement string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failing == nil {
+		b.failing = map[string]bool{}
+	}
+	b.failing[statement] = true
+}
+
+// SetFile stores a file in the guest of the named machine.
+func (b *Backend) SetFile(name, path string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vm := range b.vms {
+		if vm.Name == name {
+			vm.Files[path] = append([]byte(nil), data...)
+		}
+	}
+}
+
+// Quit simulates UTM exiting: every machine stops and scripts fail as if the
+// application was not running, until Launch is called.
+func (b *Backend) Quit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, vm := range b.vms {
+		vm.Status = utm.VmStatusStopped
+		vm.next = ""
+	}
+	b.quit = true
+}
+
+// Launch undoes Quit.
+func (b *Backend) Launch() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.quit = false
+}
+
+// Scripts returns every script run against the backend so far.
+func (b *Backend) Scripts() [][]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([][]string(nil), b.scripts...)
+}
+
+func (b *Backend) addVM(name string, backend utm.VmBackend, status utm.VmStatus, config *utm.QemuConf) *VM {
+	b.nextID++
+	vm := &VM{
+		ID:         fmt.Sprintf("00000000-0000-0000-0000-%012d", b.nextID),
+		Name:       name,
+		Backend:    backend,
+		Status:     status,
+		IPs:        []string{fmt.Sprintf("192.168.64.%d", b.nextID+1)},
+		Config:     config,
+		GuestAgent: true,
+		ACPI:       true,
+		Files:      map[string][]byte{},
+	}
+	b.vms = append(b.vms, vm)
+	return vm
+}
+
+// newMAC returns a MAC address for a network interface created without one,
+// as UTM generates a random address.
+func (b *Backend) newMAC() string {
+	b.nextMAC++
+	return fmt.Sprintf("52:54:00:00:%02x:%02x", b.nextMAC>>8&0xff, b.nextMAC&0xff)
+}
+
+func (b *Backend) advance(vm *VM) {
+	if vm.next != "" && !time.Now().Before(vm.at) {
+		vm.Status = vm.next
+		vm.next = ""
+	}
+}
+
+func (b *Backend) transition(vm *VM, via, to utm.VmStatus, delay time.Duration) {
+	if delay <= 0 {
+		vm.Status = to
+		vm.next = ""
+		return
+	}
+	vm.Status = via
+	vm.next = to
+	vm.at = time.Now().Add(delay)
+}
+
+const vmRecord = `{id: id of vm, name: name of vm, backend: backend of vm, status: status of vm}`
+
+var (
+	rePosixFile = regexp.MustCompile(`^set (\w+) to POSIX file (\(?".*"\)?)$`)
+	reBindVM    = regexp.MustCompile(`^set vm to virtual machine id (\(?".*"\)?)$`)
+	reMakeVM    = regexp.MustCompile(`^set vm to make new virtual machine with properties \{backend: (\w+), configuration: (.*)\}$`)
+	reExecute   = regexp.MustCompile(`^(?:set (\w+) to )?execute of vm at (".*?") with arguments (\{.*?\})(?: with environment (\{.*?\}))?(?: using input (".*?"))?(?: with (base64 encoding and )?(output capturing))?$`)
+	reProcessID = regexp.MustCompile(`^return id of (\w+)$`)
+	reOpenFile  = regexp.MustCompile(`^(?:set (\w+) to )?open file of vm at (".*") for (reading|writing|appending)$`)
+	reFileRef   = regexp.MustCompile(`^guest file id (\d+) of vm$`)
+	reTransfer  = regexp.MustCompile(`^(push|pull) of \((.*)\) (?:from|to) (\w+|POSIX file \(?".*"\)?)$`)
+	reFileOp    = regexp.MustCompile(`^(?:return )?(read|write|close) of \(guest file id (\d+) of vm\)(?: for length (\d+)| with data (".*"))?(?: with base64 encoding)?$`)
+	reResult    = regexp.MustCompile(`^return get result of \(guest process id (\d+) of vm\)$`)
+	reUpdate    = regexp.MustCompile(`^update configuration of vm to (.*)$`)
+	reDeleteID  = regexp.MustCompile(`^delete virtual machine id (\(?".*"\)?)$`)
+	reDeleteNm  = regexp.MustCompile(`^delete virtual machine named (\(?".*"\)?)$`)
+)
+
+type session struct {
+	vars   map[string]string
+	vm     *VM
+	output []string
+}
+
+func (b *Backend) Run(script ...string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.scripts = append(b.scripts, script)
+	if b.quit {
+		return "", scriptError(errNotRunning, "Application isn’t running.")
+	}
+
+	for _, vm := range b.vms {
+		b.advance(vm)
+	}
+
+	s := &session{vars: map[string]string{}}
+	lines := strings.Split(strings.Join(script, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+
+		if line == "repeat with vm in virtual machines" {
+			var body []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "end repeat"; i++ {
+				body = append(body, strings.TrimSpace(lines[i]))
+			}
+			for _, vm := range b.vms {
+				s.vm = vm
+				for _, stmt := range body {
+					if _, _, err := b.exec(s, stmt); err != nil {
+						return "", err
+					}
+				}
+			}
+			s.vm = nil
+			continue
+		}
+
+		res, done, err := b.exec(s, line)
+		if err != nil {
+			return "", err
+		}
+		if done {
+			return res, nil
+		}
+	}
+	return "", nil
+}
+
+func (b *Backend) exec(s *session, line string) (string, bool, error) {
+	if b.failing[line] {
+		return "", false, scriptError(errGeneral, "Simulated failure.")
+	}
+	if m := rePosixFile.FindStringSubmatch(line); m != nil {
+		path, err := unquote(m[2])
+		if err != nil {
+			return "", false, err
+		}
+		s.vars[m[1]] = path
+		return "", false, nil
+	}
+	if m := reBindVM.FindStringSubmatch(line); m != nil {
+		id, err := unquote(m[1])
+		if err != nil {
+			return "", false, err
+		}
+		vm := b.byID(id)
+		if vm == nil {
+			return "", false, scriptError(errNotFound, "Can’t get virtual machine id %s.", applescript.Quote(id))
+		}
+		s.vm = vm
+		return "", false, nil
+	}
+	if m := reMakeVM.FindStringSubmatch(line); m != nil && m[1] == string(utm.VmBackendApple) {
+		conf := &utm.AppleConf{}
+		if err := applescript.Unmarshal([]byte(m[2]), conf); err != nil || conf.Name == "" {
+			return "", false, scriptError(errGeneral, "Invalid configuration.")
+		}
+		b.fillApple(s, conf, nil, fmt.Sprintf("%d", b.nextID+1))
+		s.vm = b.addVM(conf.Name, utm.VmBackendApple, utm.VmStatusStopped, nil)
+		s.vm.AppleConfig = conf
+		return "", false, nil
+	}
+	if m := reMakeVM.FindStringSubmatch(line); m != nil {
+		conf := &utm.QemuConf{}
+		if err := applescript.Unmarshal([]byte(m[2]), conf); err != nil || conf.Name == "" {
+			return "", false, scriptError(errGeneral, "Invalid configuration.")
+		}
+		for i, drive := range conf.Drives {
+			if path, ok := s.vars[string(drive.Source)]; ok {
+				conf.Drives[i].Source = utm.QemuDriveSource(path)
+			}
+		}
+		for i := range conf.Drives {
+			if conf.Drives[i].ID == "" {
+				conf.Drives[i].ID = fmt.Sprintf("DRIVE-%d-%d", b.nextID+1, i)
+			}
+		}
+		for i := range conf.Networks {
+			conf.Networks[i].Index = i
+			if conf.Networks[i].MAC == "" {
+				conf.Networks[i].MAC = b.newMAC()
+			}
+		}
+		s.vm = b.addVM(conf.Name, utm.VmBackend(m[1]), utm.VmStatusStopped, conf)
+		return "", false, nil
+	}
+	if m := reDeleteID.FindStringSubmatch(line); m != nil {
+		id, err := unquote(m[1])
+		if err != nil {
+			return "", false, err
+		}
+		return "", false, b.delete(b.byID(id), "id "+applescript.Quote(id))
+	}
+	if m := reDeleteNm.FindStringSubmatch(line); m != nil {
+		name, err := unquote(m[1])
+		if err != nil {
+			return "", false, err
+		}
+		return "", false, b.delete(b.byName(name), "named "+applescript.Quote(name))
+	}
+
+	switch line {
+	case `return version`:
+		return applescript.Quote(b.Version), true, nil
+	case `set output to {}`:
+		s.output = []string{}
+		return "", false, nil
+	case `return output`:
+		return "{" + strings.Join(s.output, ", ") + "}", true, nil
+	}
+
+	if s.vm == nil {
+		return "", false, scriptError(errNotSupported, "Unsupported statement: %s", line)
+	}
+	vm := s.vm
+
+	if m := reUpdate.FindStringSubmatch(line); m != nil {
+		return "", false, b.update(s, vm, m[1])
+	}
+	if m := reExecute.FindStringSubmatch(line); m != nil {
+		return "", false, b.execute(s, vm, m)
+	}
+	if m := reResult.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		proc := b.processes[id]
+		if proc == nil || proc.vm != vm {
+			return "", false, scriptError(errNotFound, "Can’t get guest process id %d.", id)
+		}
+		return proc.record(), true, nil
+	}
+	if m := reOpenFile.FindStringSubmatch(line); m != nil {
+		file, err := b.openFile(vm, m[2], m[3])
+		if err != nil {
+			return "", false, err
+		}
+		if m[1] != "" {
+			s.vars[m[1]] = strconv.Itoa(file)
+		}
+		return "", false, nil
+	}
+	if m := reTransfer.FindStringSubmatch(line); m != nil {
+		return "", false, b.transfer(s, vm, m[1], m[2], m[3])
+	}
+	if m := reFileOp.FindStringSubmatch(line); m != nil {
+		return b.fileOp(vm, m)
+	}
+	if m := reProcessID.FindStringSubmatch(line); m != nil {
+		if id, ok := s.vars[m[1]]; ok {
+			return id, true, nil
+		}
+	}
+
+	switch line {
+	case "set end of output to " + vmRecord:
+		s.output = append(s.output, vm.record())
+	case "return " + vmRecord:
+		return vm.record(), true, nil
+	case `start vm`, `start vm without saving`:
+		switch vm.Status {
+		case utm.VmStatusStopped:
+			b.transition(vm, utm.VmStatusStarting, utm.VmStatusStarted, b.StartDelay)
+		case utm.VmStatusPaused:
+			b.transition(vm, utm.VmStatusResuming, utm.VmStatusStarted, b.ResumeDelay)
+		default:
+			return "", false, scriptError(errGeneral, "Operation not available.")
+		}
+	case `suspend vm`, `suspend vm with saving`:
+		if vm.Status != utm.VmStatusStarted {
+			return "", false, scriptError(errGeneral, "Operation not available.")
+		}
+		b.transition(vm, utm.VmStatusPausing, utm.VmStatusPaused, b.PauseDelay)
+	case `stop vm`:
+		if vm.Status != utm.VmStatusStarted && vm.Status != utm.VmStatusPaused {
+			return "", false, scriptError(errGeneral, "Operation not available.")
+		}
+		b.transition(vm, utm.VmStatusStopping, utm.VmStatusStopped, b.StopDelay)
+	case `stop vm by request`:
+		if vm.Status != utm.VmStatusStarted {
+			return "", false, scriptError(errGeneral, "Operation not available.")
+		}
+		if vm.ACPI {
+			b.transition(vm, utm.VmStatusStopping, utm.VmStatusStopped, b.StopDelay)
+		}
+	case `stop vm by force`:
+		if vm.Status == utm.VmStatusStopped {
+			return "", false, scriptError(errGeneral, "Operation not available.")
+		}
+		b.transition(vm, utm.VmStatusStopping, utm.VmStatusStopped, b.StopDelay)
+	case `stop vm by kill`:
+		if vm.Status == utm.VmStatusStopped {
+			return "", false, scriptError(errGeneral, "Operation not available.")
+		}
+		b.transition(vm, utm.VmStatusStopping, utm.VmStatusStopped, 0)
+	case `return configuration of vm`:
+		return vm.configuration(), true, nil
+	case `return status of vm`:
+		return string(vm.Status), true, nil
+	case `return item 1 of (query ip of vm)`:
+		if vm.Status != utm.VmStatusStarted {
+			return "", false, scriptError(errGeneral, "Virtual machine is not running.")
+		}
+		if !vm.GuestAgent || len(vm.IPs) == 0 {
+			return "", false, scriptError(errGeneral, "The QEMU guest agent is not running or not installed on the guest.")
+		}
+		return applescript.Quote(vm.IPs[0]), true, nil
+	case `return query ip of vm`:
+		if vm.Status != utm.VmStatusStarted {
+			return "", false, scriptError(errGeneral, "Virtual machine is not running.")
+		}
+		if !vm.GuestAgent {
+			return "", false, scriptError(errGeneral, "The QEMU guest agent is not running or not installed on the guest.")
+		}
+		ips := make([]string, len(vm.IPs))
+		for i, ip := range vm.IPs {
+			ips[i] = applescript.Quote(ip)
+		}
+		return "{" + strings.Join(ips, ", ") + "}", true, nil
+	default:
+		return "", false, scriptError(errNotSupported, "Unsupported statement: %s", line)
+	}
+	return "", false, nil
+}
+
+// execute runs a command matched by reExecute. Commands that capture their
+// output become processes whose result is read with `get result`.
+func (b *Backend) execute(s *session, vm *VM, m []string) error {
+	file, err := unquote(m[2])
+	if err != nil {
+		return err
+	}
+	cmd := Command{Path: file}
+	if err := applescript.Unmarshal([]byte(m[3]), &cmd.Args); err != nil {
+		return scriptError(errGeneral, "Invalid arguments.")
+	}
+	if m[4] != "" {
+		if err := applescript.Unmarshal([]byte(m[4]), &cmd.Env); err != nil {
+			return scriptError(errGeneral, "Invalid environment.")
+		}
+	}
+	if m[5] != "" {
+		input, err := unquote(m[5])
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = []byte(input)
+		if m[6] != "" {
+			if cmd.Stdin, err = base64.StdEncoding.DecodeString(input); err != nil {
+				return scriptError(errGeneral, "Invalid base64 input.")
+			}
+		}
+	}
+
+	if err := b.guestAgent(vm); err != nil {
+		return err
+	}
+	vm.Executed = append(vm.Executed, cmd.Path)
+	if path.Base(cmd.Path) == "poweroff" {
+		b.transition(vm, utm.VmStatusStopping, utm.VmStatusStopped, b.StopDelay)
+	}
+	if m[7] == "" {
+		return nil
+	}
+
+	var result utm.ExecResult
+	if b.Exec != nil {
+		result = b.Exec(vm.Name, cmd)
+	}
+	if b.processes == nil {
+		b.processes = map[int]*process{}
+	}
+	id := len(b.processes) + 1
+	b.processes[id] = &process{vm: vm, result: result, done: time.Now().Add(b.ExecDelay)}
+	if m[1] != "" {
+		s.vars[m[1]] = strconv.Itoa(id)
+	}
+	return nil
+}
+
+func (b *Backend) guestAgent(vm *VM) error {
+	if vm.Status != utm.VmStatusStarted {
+		return scriptError(errGeneral, "Virtual machine is not running.")
+	}
+	if !vm.GuestAgent {
+		return scriptError(errGeneral, "The QEMU guest agent is not running or not installed on the guest.")
+	}
+	return nil
+}
+
+// openFile opens a guest file and returns its id. Writing truncates the file.
+func (b *Backend) openFile(vm *VM, quoted, mode string) (int, error) {
+	file, err := unquote(quoted)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.guestAgent(vm); err != nil {
+		return 0, err
+	}
+	f := &guestFile{vm: vm, path: file}
+	switch _, ok := vm.Files[file]; {
+	case mode == "reading" && !ok:
+		return 0, scriptError(errGeneral, "No such file or directory.")
+	case mode == "writing" || !ok:
+		vm.Files[file] = []byte{}
+	case mode == "appending":
+		f.offset = len(vm.Files[file])
+	}
+	if b.files == nil {
+		b.files = map[int]*guestFile{}
+	}
+	id := len(b.files) + 1
+	b.files[id] = f
+	return id, nil
+}
+
+func (b *Backend) file(vm *VM, id string) (*guestFile, error) {
+	n, _ := strconv.Atoi(id)
+	f := b.files[n]
+	if f == nil || f.vm != vm || f.closed {
+		return nil, scriptError(errNotFound, "Can’t get guest file id %s.", id)
+	}
+	return f, nil
+}
+
+// transfer pushes or pulls a whole host file, closing the guest file.
+func (b *Backend) transfer(s *session, vm *VM, verb, ref, host string) error {
+	var f *guestFile
+	if m := reOpenFile.FindStringSubmatch(ref); m != nil {
+		id, err := b.openFile(vm, m[2], m[3])
+		if err != nil {
+			return err
+		}
+		f = b.files[id]
+	} else if m := reFileRef.FindStringSubmatch(ref); m != nil {
+		var err error
+		if f, err = b.file(vm, m[1]); err != nil {
+			return err
+		}
+	} else {
+		return scriptError(errNotSupported, "Unsupported file reference: %s", ref)
+	}
+
+	hostPath, ok := s.vars[host]
+	if quoted, isFile := strings.CutPrefix(host, "POSIX file "); isFile {
+		var err error
+		if hostPath, err = unquote(quoted); err != nil {
+			return err
+		}
+	} else if !ok {
+		return scriptError(errNotFound, "The variable %s is not defined.", host)
+	}
+
+	f.closed = true
+	if verb == "push" {
+		data, err := os.ReadFile(hostPath)
+		if err != nil {
+			return scriptError(errGeneral, "%v", err)
+		}
+		vm.Files[f.path] = data
+		return nil
+	}
+	if err := os.WriteFile(hostPath, vm.Files[f.path][f.offset:], 0644); err != nil {
+		return scriptError(errGeneral, "%v", err)
+	}
+	return nil
+}
+
+// fileOp runs read, write or close on a guest file matched by reFileOp.
+func (b *Backend) fileOp(vm *VM, m []string) (string, bool, error) {
+	if err := b.guestAgent(vm); err != nil {
+		return "", false, err
+	}
+	f, err := b.file(vm, m[2])
+	if err != nil {
+		return "", false, err
+	}
+	data := vm.Files[f.path]
+	switch m[1] {
+	case "read":
+		n, _ := strconv.Atoi(m[3])
+		end := min(len(data), f.offset+n)
+		chunk := data[f.offset:end]
+		f.offset = end
+		return applescript.Quote(base64.StdEncoding.EncodeToString(chunk)), true, nil
+	case "write":
+		encoded, err := unquote(m[4])
+		if err != nil {
+			return "", false, err
+		}
+		chunk, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", false, scriptError(errGeneral, "Invalid base64 data.")
+		}
+		vm.Files[f.path] = append(data[:f.offset:f.offset], chunk...)
+		f.offset += len(chunk)
+	case "close":
+		f.closed = true
+	}
+	return "", false, nil
+}
+
+// record renders the `execute result` of the process, with output in base64
+// as UTM reports it.
+func (p *process) record() string {
+	if time.Now().Before(p.done) {
+		return `{exited:false, exit code:0, signal code:0}`
+	}
+	return fmt.Sprintf(`{exited:true, exit code:%d, signal code:%d, output base64:%s, error base64:%s}`,
+		p.result.ExitCode, p.result.Signal,
+		applescript.Quote(base64.StdEncoding.EncodeToString(p.result.Stdout)),
+		applescript.Quote(base64.StdEncoding.EncodeToString(p.result.Stderr)))
+}
+
+func (vm *VM) record() string {
+	res, _ := applescript.Marshal(&utm.VM{ID: vm.ID, Name: vm.Name, Backend: vm.Backend, Status: vm.Status})
+	return string(res)
+}
+
+// configuration renders the stored configuration the way UTM reports it,
+// without the write-only drive sources.
+func (vm *VM) configuration() string {
+	if vm.AppleConfig != nil {
+		conf := *vm.AppleConfig
+		conf.Drives = make([]utm.AppleDriveConf, len(vm.AppleConfig.Drives))
+		for i, drive := range vm.AppleConfig.Drives {
+			drive.Source = ""
+			conf.Drives[i] = drive
+		}
+		conf.DirectoryShares = make([]utm.AppleDirectoryShareConf, len(vm.AppleConfig.DirectoryShares))
+		for i, share := range vm.AppleConfig.DirectoryShares {
+			share.Source = ""
+			conf.DirectoryShares[i] = share
+		}
+		res, _ := applescript.Marshal(&conf)
+		return string(res)
+	}
+
+	conf := *vm.Config
+	conf.Drives = make([]utm.QemuDriveConf, len(vm.Config.Drives))
+	for i, drive := range vm.Config.Drives {
+		drive.Source = ""
+		conf.Drives[i] = drive
+	}
+	res, _ := applescript.Marshal(&conf)
+	return string(res)
+}
+
+// update mirrors UTM: drives are matched by id and dropped when missing from
+// the new list, drives without an id are created, and network interfaces are
+// renumbered in order.
+func (b *Backend) update(s *session, vm *VM, record string) error {
+	if vm.Status != utm.VmStatusStopped {
+		return scriptError(errGeneral, "Operation not available.")
+	}
+	if vm.AppleConfig != nil {
+		conf := &utm.AppleConf{}
+		if err := applescript.Unmarshal([]byte(record), conf); err != nil || conf.Name == "" {
+			return scriptError(errGeneral, "Invalid configuration.")
+		}
+		if err := b.fillApple(s, conf, vm.AppleConfig, vm.ID); err != nil {
+			return err
+		}
+		vm.Name = conf.Name
+		vm.AppleConfig = conf
+		return nil
+	}
+
+	conf := &utm.QemuConf{}
+	if err := applescript.Unmarshal([]byte(record), conf); err != nil || conf.Name == "" {
+		return scriptError(errGeneral, "Invalid configuration.")
+	}
+
+	existing := map[string]utm.QemuDriveConf{}
+	for _, drive := range vm.Config.Drives {
+		existing[drive.ID] = drive
+	}
+	for i, drive := range conf.Drives {
+		if drive.ID == "" {
+			conf.Drives[i].ID = fmt.Sprintf("DRIVE-%s-%d", vm.ID, i)
+		} else if old, ok := existing[drive.ID]; ok && drive.Source == "" {
+			conf.Drives[i].Source = old.Source
+		} else if !ok {
+			return scriptError(errNotFound, "Can’t get drive id %s.", applescript.Quote(drive.ID))
+		}
+		if path, ok := s.vars[string(drive.Source)]; ok {
+			conf.Drives[i].Source = utm.QemuDriveSource(path)
+		}
+	}
+	for i := range conf.Networks {
+		conf.Networks[i].Index = i
+		if conf.Networks[i].MAC == "" {
+			conf.Networks[i].MAC = b.newMAC()
+		}
+	}
+
+	vm.Name = conf.Name
+	vm.Config = conf
+	return nil
+}
+
+// fillApple resolves source variables, keeps the sources of existing drives and
+// shares, and assigns ids and indexes the way UTM does.
+func (b *Backend) fillApple(s *session, conf, current *utm.AppleConf, prefix string) error {
+	drives := map[string]utm.AppleDriveConf{}
+	shares := map[string]utm.AppleDirectoryShareConf{}
+	if current != nil {
+		for _, drive := range current.Drives {
+			drives[drive.ID] = drive
+		}
+		for _, share := range current.DirectoryShares {
+			shares[share.ID] = share
+		}
+	}
+
+	for i, drive := range conf.Drives {
+		if drive.ID == "" {
+			conf.Drives[i].ID = fmt.Sprintf("DRIVE-%s-%d", prefix, i)
+		} else if old, ok := drives[drive.ID]; ok && drive.Source == "" {
+			conf.Drives[i].Source = old.Source
+		} else if !ok {
+			return scriptError(errNotFound, "Can’t get drive id %s.", applescript.Quote(drive.ID))
+		}
+		if path, ok := s.vars[string(drive.Source)]; ok {
+			conf.Drives[i].Source = utm.AppleDriveSource(path)
+		}
+	}
+	for i, share := range conf.DirectoryShares {
+		if share.ID == "" {
+			conf.DirectoryShares[i].ID = fmt.Sprintf("SHARE-%s-%d", prefix, i)
+		} else if old, ok := shares[share.ID]; ok && share.Source == "" {
+			conf.DirectoryShares[i].Source = old.Source
+		} else if !ok {
+			return scriptError(errNotFound, "Can’t get directory share id %s.", applescript.Quote(share.ID))
+		}
+		if path, ok := s.vars[string(share.Source)]; ok {
+			conf.DirectoryShares[i].Source = utm.AppleDirectoryShareSource(path)
+		}
+	}
+	for i := range conf.Networks {
+		conf.Networks[i].Index = i
+		if conf.Networks[i].MAC == "" {
+			conf.Networks[i].MAC = b.newMAC()
+		}
+	}
+	return nil
+}
+
+func (b *Backend) delete(vm *VM, ref string) error {
+	if vm == nil {
+		return scriptError(errNotFound, "Can’t get virtual machine %s.", ref)
+	}
+	if vm.Status != utm.VmStatusStopped {
+		return scriptError(errGeneral, "Operation not available.")
+	}
+	for i, v := range b.vms {
+		if v == vm {
+			b.vms = append(b.vms[:i], b.vms[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (b *Backend) byID(id string) *VM {
+	for _, vm := range b.vms {
+		if vm.ID == id {
+			return vm
+		}
+	}
+	return nil
+}
+
+func (b *Backend) byName(name string) *VM {
+	for _, vm := range b.vms {
+		if vm.Name == name {
+			return vm
+		}
+	}
+	return nil
+}
+
+// unquote decodes a text literal from a script, failing the way AppleScript
+// reports syntax errors.
+func unquote(s string) (string, error) {
+	text, err := applescript.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("exit status 1: syntax error: %v (-2740)", err)
+	}
+	return text, nil
+}
+
+func scriptError(code int, format string, args ...any) error {
+	return fmt.Errorf("exit status 1: execution error: UTM got an error: %s (%d)", fmt.Sprintf(format, args...), code)
+}
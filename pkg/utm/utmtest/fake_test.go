@@ -0,0 +1,268 @@
+package utmtest
+
+import (
+	"bytes"
+	"context"
+	"docker-machine-driver-utm/pkg/utm"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecycle(t *testing.T) {
+	backend := New()
+	backend.StartDelay = 50 * time.Millisecond
+	client := backend.Client()
+
+	vm, err := client.CreateQemuVM(&utm.QemuConf{
+		Name:   "fake-test",
+		Memory: 512,
+		Drives: []utm.QemuDriveConf{{Removable: true, Source: "/tmp/boot2docker.iso"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != "fake-test" || vm.Status != utm.VmStatusStopped {
+		t.Fatalf("unexpected vm: %+v", vm)
+	}
+
+	if err := vm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	status, err := vm.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != utm.VmStatusStarting {
+		t.Fatalf("expected starting, got %s", status)
+	}
+	if _, err := vm.GetIP(); err == nil {
+		t.Fatal("expected query ip to fail while starting")
+	}
+
+	time.Sleep(backend.StartDelay)
+	status, err = vm.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != utm.VmStatusStarted {
+		t.Fatalf("expected started, got %s", status)
+	}
+	ip, err := vm.GetIP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip == "" {
+		t.Fatal("expected an ip address")
+	}
+
+	if err := client.DeleteVmByID(vm.ID); err == nil {
+		t.Fatal("expected delete of a running vm to fail")
+	}
+	if err := vm.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteVmByID(vm.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetVmByName("fake-test"); !errors.Is(err, utm.ErrVMNotFound) {
+		t.Fatalf("expected vm to be gone, got %v", err)
+	}
+	if err := vm.Start(); !errors.Is(err, utm.ErrVMNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestQuotedNames(t *testing.T) {
+	backend := New()
+	client := backend.Client()
+	name := `odd "name" \ with` + "\x1b"
+	vm, err := client.CreateQemuVM(&utm.QemuConf{
+		Name:   name,
+		Drives: []utm.QemuDriveConf{{Removable: true, Source: `/tmp/a "b".iso`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Name != name {
+		t.Fatalf("unexpected name %q", vm.Name)
+	}
+	if got, _ := backend.VM(name); got.Config.Drives[0].Source != `/tmp/a "b".iso` {
+		t.Fatalf("unexpected drive source %q", got.Config.Drives[0].Source)
+	}
+	if err := vm.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RunCommandOnVM(vm, `/bin/"sh"`, "-c", `echo "hi"`); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := backend.VM(name); len(got.Executed) != 1 || got.Executed[0] != `/bin/"sh"` {
+		t.Fatalf("unexpected commands %q", got.Executed)
+	}
+	if err := vm.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteVmByName(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuestAgentUnavailable(t *testing.T) {
+	backend := New()
+	backend.AddVM("no-agent", utm.VmStatusStarted)
+	backend.SetGuestAgent("no-agent", false)
+
+	vm, err := backend.Client().GetVmByName("no-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.GetIP(); !errors.Is(err, utm.ErrGuestAgentUnavailable) {
+		t.Fatalf("expected guest agent error, got %v", err)
+	}
+}
+
+func TestGetConfiguration(t *testing.T) {
+	client := New().Client()
+	vm, err := client.CreateQemuVM(&utm.QemuConf{
+		Name:   "conf-test",
+		Memory: 2048,
+		CPU:    2,
+		Drives: []utm.QemuDriveConf{
+			{Removable: true, Source: "/tmp/boot2docker.iso"},
+			{Raw: true, Interface: utm.QemuDriveInterfaceIDE, Source: "/tmp/disk.img"},
+		},
+		Networks: []utm.QemuNetworkConf{{Mode: utm.QemuNetworkModeShared}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := vm.GetConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Name != "conf-test" || conf.Memory != 2048 || conf.CPU != 2 {
+		t.Fatalf("unexpected configuration: %+v", conf)
+	}
+	if len(conf.Drives) != 2 || conf.Drives[0].ID == "" || conf.Drives[1].Source != "" {
+		t.Fatalf("unexpected drives: %+v", conf.Drives)
+	}
+	if len(conf.Networks) != 1 || conf.Networks[0].Mode != utm.QemuNetworkModeShared {
+		t.Fatalf("unexpected networks: %+v", conf.Networks)
+	}
+}
+
+func TestExec(t *testing.T) {
+	backend := New()
+	backend.ExecDelay = 300 * time.Millisecond
+	backend.Exec = func(vm string, cmd Command) utm.ExecResult {
+		if cmd.Path != "/bin/sh" || !slices.Equal(cmd.Args, []string{"-c", "cat; echo $GREETING >&2; exit 3"}) {
+			return utm.ExecResult{ExitCode: 127}
+		}
+		return utm.ExecResult{ExitCode: 3, Stdout: cmd.Stdin, Stderr: []byte(strings.TrimPrefix(cmd.Env[0], "GREETING=") + "\n")}
+	}
+	backend.AddVM("exec", utm.VmStatusStarted)
+	vm, err := backend.Client().GetVmByName("exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := utm.Exec(ctx, vm, "/bin/sh", []string{"-c", "cat; echo $GREETING >&2; exit 3"}, &utm.ExecOptions{
+		Env:   []string{"GREETING=hello"},
+		Stdin: []byte("\x00binary\xff"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 3 || string(res.Stdout) != "\x00binary\xff" || string(res.Stderr) != "hello\n" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := utm.Exec(short, vm, "/bin/true", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	backend.SetGuestAgent("exec", false)
+	if _, err := utm.Exec(ctx, vm, "/bin/true", nil, nil); !errors.Is(err, utm.ErrGuestAgentUnavailable) {
+		t.Fatalf("expected guest agent error, got %v", err)
+	}
+}
+
+func TestGuestFile(t *testing.T) {
+	backend := New()
+	backend.AddVM("files", utm.VmStatusStarted)
+	vm, err := backend.Client().GetVmByName("files")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef\x00\xff"), 150000)
+	f, err := utm.OpenGuestFile(vm, "/tmp/big", utm.GuestFileModeWriting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(f, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = utm.OpenGuestFile(vm, "/tmp/big", utm.GuestFileModeAppending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("tail")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = utm.OpenGuestFile(vm, "/tmp/big", utm.GuestFileModeReading)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), append(data, "tail"...)) {
+		t.Fatalf("read %d bytes, want %d", got.Len(), len(data)+4)
+	}
+	if _, err := f.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected read after close to fail")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("pushed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := utm.CopyToVM(vm, src, "/tmp/pushed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := utm.CopyFromVM(vm, "/tmp/pushed", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "pushed" {
+		t.Fatalf("unexpected pulled contents %q", b)
+	}
+	if _, err := utm.OpenGuestFile(vm, "/tmp/missing", utm.GuestFileModeReading); err == nil {
+		t.Fatal("expected opening a missing file to fail")
+	}
+}
@@ -0,0 +1,121 @@
+package utm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	waitInitialInterval = 250 * time.Millisecond
+	waitMaxInterval     = 5 * time.Second
+)
+
+func WaitForStatus(ctx context.Context, vm *VM, statuses ...VmStatus) error {
+	return vm.utm().WaitForStatus(ctx, vm, statuses...)
+}
+
+func WaitForIP(ctx context.Context, vm *VM) (string, error) {
+	return vm.utm().WaitForIP(ctx, vm)
+}
+
+func WaitForIPFunc(ctx context.Context, vm *VM, choose func(ips []string) string) (string, error) {
+	return vm.utm().WaitForIPFunc(ctx, vm, choose)
+}
+
+// WaitForStatus polls the VM with exponential backoff until it reaches one of
+// statuses or ctx is done. The error reports the last status observed.
+func (c *Client) WaitForStatus(ctx context.Context, vm *VM, statuses ...VmStatus) error {
+	var last VmStatus
+	var lastErr error
+	err := poll(ctx, func() bool {
+		last, lastErr = c.GetStatus(vm)
+		return lastErr == nil && slices.Contains(statuses, last)
+	})
+	if err != nil {
+		return waitError(fmt.Sprintf("status %v", statuses), err, last, lastErr)
+	}
+	return nil
+}
+
+// WaitForIP polls the VM with exponential backoff until the guest reports an
+// IP address. It gives up early when the VM stops while waiting.
+func (c *Client) WaitForIP(ctx context.Context, vm *VM) (string, error) {
+	return c.WaitForIPFunc(ctx, vm, func(ips []string) string {
+		if len(ips) == 0 {
+			return ""
+		}
+		return ips[0]
+	})
+}
+
+// WaitForIPFunc is like WaitForIP, but waits until choose picks a non-empty
+// address among those the guest reports. choose is called with nil while the
+// guest agent is unavailable, so it can find the address by other means.
+func (c *Client) WaitForIPFunc(ctx context.Context, vm *VM, choose func(ips []string) string) (string, error) {
+	var ip string
+	var last VmStatus
+	var lastErr error
+	stopped := false
+	err := poll(ctx, func() bool {
+		last, lastErr = c.GetStatus(vm)
+		if lastErr != nil {
+			return false
+		}
+		if last == VmStatusStopped {
+			stopped = true
+			return true
+		}
+		if last != VmStatusStarted {
+			return false
+		}
+		var ips []string
+		ips, lastErr = c.GetIPs(vm)
+		if lastErr != nil && !errors.Is(lastErr, ErrGuestAgentUnavailable) {
+			return false
+		}
+		ip = choose(ips)
+		return ip != ""
+	})
+	if stopped {
+		return "", fmt.Errorf("VM stopped while waiting for an IP address")
+	}
+	if err != nil {
+		return "", waitError("an IP address", err, last, lastErr)
+	}
+	return ip, nil
+}
+
+func waitError(what string, err error, last VmStatus, lastErr error) error {
+	if last == "" {
+		last = "unknown"
+	}
+	if lastErr != nil {
+		return fmt.Errorf("waiting for %s: %w (last status: %s, last error: %v)", what, err, last, lastErr)
+	}
+	return fmt.Errorf("waiting for %s: %w (last status: %s)", what, err, last)
+}
+
+func poll(ctx context.Context, done func() bool) error {
+	interval := waitInitialInterval
+	for {
+		if done() {
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if interval > waitMaxInterval {
+			interval = waitMaxInterval
+		}
+	}
+}
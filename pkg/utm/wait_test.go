@@ -0,0 +1,68 @@
+package utm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sequenceClient(statuses ...string) *Client {
+	i := 0
+	return NewClient(RunnerFunc(func(script ...string) (string, error) {
+		cmd := script[len(script)-1]
+		switch cmd {
+		case `return status of vm`:
+			status := statuses[min(i, len(statuses)-1)]
+			i++
+			return status, nil
+		case `return query ip of vm`:
+			return `{"192.168.64.2", "fe80::1"}`, nil
+		}
+		return "", errors.New("unexpected script: " + cmd)
+	}))
+}
+
+func TestWaitForStatus(t *testing.T) {
+	client := sequenceClient("started", "stopping", "stopped")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.WaitForStatus(ctx, &VM{ID: "abc"}, VmStatusStopped); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForStatusTimeout(t *testing.T) {
+	client := sequenceClient("stopping")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := client.WaitForStatus(ctx, &VM{ID: "abc"}, VmStatusStopped)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "last status: stopping") {
+		t.Fatalf("expected last status in error, got %v", err)
+	}
+}
+
+func TestWaitForIP(t *testing.T) {
+	client := sequenceClient("starting", "started")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ip, err := client.WaitForIP(ctx, &VM{ID: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "192.168.64.2" {
+		t.Fatalf("unexpected ip: %s", ip)
+	}
+
+	client = sequenceClient("starting", "stopped")
+	if _, err := client.WaitForIP(ctx, &VM{ID: "abc"}); err == nil {
+		t.Fatal("expected an error when the VM stops while booting")
+	}
+}